@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"go.uber.org/zap/zapcore"
+)
+
+// Fetches a path with the same github client & pagination the server uses and prints the body,
+// handy for checking tokens & pagination without running the server.
+func get(args []string) error {
+	var single, pretty, verbose bool
+	var github githubFlags
+	flags := newFlagSet("get", "<path>")
+	flags.BoolVar(&single, "single", false, "Fetch a single page rather than following pagination")
+	flags.BoolVar(&pretty, "pretty", false, "Indent the json output")
+	flags.BoolVar(&verbose, "v", false, "Log each github request with its status & rate limit remaining")
+	github.register(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	logger := newLoggerAt(verbose, zapcore.DebugLevel)
+	defer logger.Sync()
+	loadEnv(logger)
+	tokens, err := github.tokens(logger)
+	if err != nil {
+		return err
+	}
+	client, err := github.client(tokens, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	fetch := client.FetchAll
+	if single {
+		fetch = client.Fetch
+	}
+	body, err := fetch(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err == nil {
+			body = indented.Bytes()
+		}
+	}
+	if _, err := os.Stdout.Write(append(body, '\n')); err != nil {
+		return err
+	}
+	if reporter, ok := client.(apiclient.StatusReporter); ok && verbose {
+		status := reporter.Status()
+		if status.RateLimit != nil {
+			fmt.Fprintf(os.Stderr, "Rate limit: %d of %d left, resets at %s\n",
+				status.RateLimit.Remaining, status.RateLimit.Limit, status.RateLimit.Reset.Local().Format("15:04:05"))
+		}
+		if status.LastError != "" {
+			fmt.Fprintf(os.Stderr, "Last error: %s\n", status.LastError)
+		}
+	}
+	return nil
+}
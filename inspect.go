@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+// An entry as listed by inspect, with how many past versions are kept for it.
+type inspectEntry struct {
+	datasource.EntryInfo
+	Age     string `json:"age"`
+	History int    `json:"history"`
+}
+
+// Lists the entries in a snapshot written by warm or by serve with NFCACHE_STORE_PATH set.
+func inspect(args []string) error {
+	var asJSON bool
+	flags := newFlagSet("inspect", "<snapshot>")
+	flags.BoolVar(&asJSON, "json", false, "Print the entries as json")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); err != nil { // Opening would create an empty one
+		return err
+	}
+	store, err := datasource.NewDiskStore(path)
+	if err != nil {
+		return fmt.Errorf("unable to open %s, a running server may have it open: %w", path, err)
+	}
+	defer store.Close()
+	entries, err := listEntries(store, time.Now())
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	return printEntries(os.Stdout, entries)
+}
+
+// Entries in the store sorted by path.
+func listEntries(store datasource.Store, now time.Time) ([]inspectEntry, error) {
+	metas, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]inspectEntry, 0, len(metas))
+	for path, meta := range metas {
+		snapshots, err := store.Snapshots(path)
+		if err != nil {
+			return nil, err
+		}
+		entry := inspectEntry{
+			EntryInfo: datasource.EntryInfo{
+				Path:        path,
+				Size:        meta.Size,
+				Version:     meta.Version,
+				EncodedSize: meta.EncodedSize,
+				LastUpdated: meta.LastUpdated,
+				Watched:     meta.Watched,
+				LastError:   meta.LastError,
+			},
+			Age:     now.Sub(meta.LastUpdated).Round(time.Second).String(),
+			History: len(snapshots),
+		}
+		if !meta.LastErrorAt.IsZero() {
+			entry.LastErrorAt = &meta.LastErrorAt
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func printEntries(w io.Writer, entries []inspectEntry) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "PATH\tWATCHED\tSIZE\tGZIP\tAGE\tVERSION\tHISTORY\tLAST ERROR")
+	for _, entry := range entries {
+		gzipped := "-"
+		if size, ok := entry.EncodedSize["gzip"]; ok {
+			gzipped = formatSize(size)
+		}
+		fmt.Fprintf(table, "%s\t%t\t%s\t%s\t%s\t%s\t%d\t%s\n", entry.Path, entry.Watched, formatSize(entry.Size),
+			gzipped, entry.Age, entry.Version, entry.History, entry.LastError)
+	}
+	return table.Flush()
+}
+
+// Bytes in the largest unit that keeps the number at least 1, e.g. 12.3 KiB.
+func formatSize(size int) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		value /= 1024
+		if value < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return "" // Unreachable
+}
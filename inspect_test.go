@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSize(t *testing.T) {
+	cases := map[int]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		1024:       "1.0 KiB",
+		12_595:     "12.3 KiB",
+		5 << 20:    "5.0 MiB",
+		3 << 30:    "3.0 GiB",
+		4096 << 30: "4096.0 GiB",
+	}
+	for size, expected := range cases {
+		assert.Equal(t, expected, formatSize(size), "%d bytes", size)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	cache, store, fake := tCache(t)
+	assert.Nil(t, cache.WatchEndpoint("/orgs/Netflix/repos"))
+	fake.SetRepos("Netflix", nil)
+	assert.Nil(t, cache.Refresh("/orgs/Netflix/repos"))
+	_, err := cache.Fetch(context.Background(), "/orgs/Netflix/members") // Read-through, not kept
+	assert.Nil(t, err)
+	assert.Nil(t, cache.WatchEndpoint("/orgs/Netflix"))
+
+	repos, _ := cache.Entry("/orgs/Netflix/repos")
+	entries, err := listEntries(store, repos.LastUpdated.Add(90*time.Second))
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "/orgs/Netflix", entries[0].Path, "sorted by path")
+		assert.Equal(t, "/orgs/Netflix/repos", entries[1].Path)
+		assert.Equal(t, "1m30s", entries[1].Age)
+		assert.Equal(t, 2, entries[1].History, "a version per change")
+		assert.Equal(t, repos.Version, entries[1].Version)
+		assert.True(t, entries[1].Watched)
+	}
+
+	var out bytes.Buffer
+	assert.Nil(t, printEntries(&out, entries))
+	assert.Contains(t, out.String(), "PATH")
+	assert.Regexp(t, `/orgs/Netflix/repos\s+true\s+2 B\s`, out.String())
+}
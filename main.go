@@ -2,11 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,56 +14,230 @@ import (
 	"github.com/njo/nfcache/pkg/apiserver"
 	"github.com/njo/nfcache/pkg/datasource"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+const usage = `Usage: nfcache [command] [flags]
+
+Commands:
+  serve              Run the caching api server (the default when no command is given)
+  warm               Fetch the cached endpoints into a snapshot file, then exit
+  get <path>         Fetch a path through the github client and print the result
+  inspect <snapshot> List the entries in a snapshot with their ages and sizes
+
+Run nfcache <command> -h for the command's flags.
+`
+
+// Each command parses its own flags from the args after the command name.
+var commands = map[string]func(args []string) error{
+	"serve":   serve,
+	"warm":    warm,
+	"get":     get,
+	"inspect": inspect,
+}
+
 func main() {
-	// Load CLI Options
-	var port int
-	flag.IntVar(&port, "p", 8080, "Set the port number to listen on (Default 8080)")
-	flag.Parse()
+	command, args := parseCommand(os.Args[1:])
+	if command == "help" {
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "nfcache %s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// Splits the command name from its args, serve is the default.
+func parseCommand(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") { // Plain flags keep working as serve flags
+		return args[0], args[1:]
+	}
+	return "serve", args
+}
+
+// Flag set for a command that prints its usage line before the flags.
+func newFlagSet(name string, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), strings.TrimSpace("Usage: nfcache "+name+" [flags] "+args))
+		flags.PrintDefaults()
+	}
+	return flags
+}
 
-	// Set up logger
-	zLogger, err := zap.NewProduction()
+// Production logger for the server & verbose output, a no-op logger otherwise.
+func newLogger(enabled bool) *zap.SugaredLogger {
+	return newLoggerAt(enabled, zapcore.InfoLevel)
+}
+
+// Verbose commands log at debug so each github request is shown.
+func newLoggerAt(enabled bool, level zapcore.Level) *zap.SugaredLogger {
+	if !enabled {
+		return zap.NewNop().Sugar()
+	}
+	config := zap.NewProductionConfig()
+	config.Level = zap.NewAtomicLevelAt(level)
+	zLogger, err := config.Build()
 	if err != nil {
 		log.Fatalf("can't initialize zap logger: %v\n", err)
 	}
-	defer zLogger.Sync()      // ensure logger buffer flushed on shutdown
-	logger := zLogger.Sugar() // Sugar logger allows for printf style formatting
+	return zLogger.Sugar() // Sugar logger allows for printf style formatting
+}
 
-	// Load env vars
-	err = godotenv.Load() // Adds .env file into regular os.env vars
-	if err == nil {
+// Adds the .env file, if there is one, into the regular env vars.
+func loadEnv(logger *zap.SugaredLogger) {
+	if err := godotenv.Load(); err == nil {
 		logger.Info("Loaded .env file")
 	}
-	githubToken := os.Getenv("GITHUB_API_TOKEN")
-	if githubToken == "" {
-		logger.Warn("Unable to load GITHUB_API_TOKEN")
+}
+
+// Where github responses come from, shared by the commands that talk to github.
+type githubFlags struct {
+	recordDir string
+	replayDir string
+}
+
+func (g *githubFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&g.recordDir, "record", "", "Save every github response to this fixtures directory")
+	flags.StringVar(&g.replayDir, "replay", "", "Serve github responses from this fixtures directory instead of the network")
+}
+
+// Token source for github requests from the GITHUB_* env vars. Replays don't need auth so app auth is skipped.
+func (g *githubFlags) tokens(logger *zap.SugaredLogger) (apiclient.TokenSource, error) {
+	githubTokens := append(envList("GITHUB_API_TOKEN"), envList("GITHUB_API_TOKENS")...)
+	if len(githubTokens) == 0 && os.Getenv("GITHUB_APP_ID") == "" {
+		logger.Warn("Unable to load GITHUB_API_TOKEN or GITHUB_API_TOKENS")
+	}
+	githubApp, err := loadGithubApp()
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up github app auth: %w", err)
+	}
+	if githubApp != nil && g.replayDir == "" {
+		logger.Info("Authenticating as a github app installation")
+		return githubApp, nil
+	}
+	return apiclient.NewTokenPool(githubTokens...), nil
+}
+
+// Github client that records or replays responses when asked to.
+func (g *githubFlags) client(tokens apiclient.TokenSource, logger *zap.SugaredLogger) (apiclient.ApiClient, error) {
+	opts := apiclient.GithubOptions{Tokens: tokens, Logger: logger}
+	switch {
+	case g.replayDir != "":
+		logger.Infof("Replaying github responses from %s, nothing is fetched from github", g.replayDir)
+		return apiclient.NewReplay(g.replayDir, opts)
+	case g.recordDir != "":
+		logger.Infof("Recording github responses to %s", g.recordDir)
+		return apiclient.NewRecording(g.recordDir, opts)
 	}
+	return apiclient.NewGithubWithOptions(opts), nil
+}
 
-	// Init servers
-	githubClient := apiclient.NewGithub(githubToken)
-	apiCache := datasource.NewCachedAPI(githubClient, logger)
-	server := apiserver.New(apiCache, logger)
-	initalEndpoints := apiserver.CachedEndpoints()
-	logger.Info("Pre-fetching initial endpoint data")
-	for _, path := range initalEndpoints {
-		logger.Infof("Fetching %s", path)
-		err = apiCache.WatchEndpoint(path)
+// Github clients for proxied traffic & cache refreshes wrapped in circuit breakers,
+// refreshes get their own breaker when GITHUB_REFRESH_TOKENS gives them their own pool.
+func (g *githubFlags) breakers(logger *zap.SugaredLogger) ([]*apiclient.Breaker, error) {
+	tokens, err := g.tokens(logger)
+	if err != nil {
+		return nil, err
+	}
+	// Fail fast instead of waiting out timeouts while github is down
+	logBreaker := func(name string, from apiclient.BreakerState, to apiclient.BreakerState) {
+		logger.Warnf("Upstream circuit %s went from %s to %s", name, from, to)
+	}
+	client, err := g.client(tokens, logger)
+	if err != nil {
+		return nil, err
+	}
+	breakers := []*apiclient.Breaker{apiclient.NewBreaker(client, apiclient.BreakerOptions{Name: "github", OnStateChange: logBreaker})}
+	if refreshTokens := envList("GITHUB_REFRESH_TOKENS"); len(refreshTokens) > 0 {
+		logger.Infof("Using a separate pool of %d tokens for cache refreshes", len(refreshTokens))
+		refreshClient, err := g.client(apiclient.NewTokenPool(refreshTokens...), logger)
 		if err != nil {
-			// Choosing to early exit here as there's probably an external api issue
-			logger.Fatalf("unable to fetch %s on startup: %v\n", path, err)
+			return nil, err
 		}
+		breakers = append(breakers, apiclient.NewBreaker(refreshClient, apiclient.BreakerOptions{Name: "github-refresh", OnStateChange: logBreaker}))
 	}
-	apiCache.Run(datasource.DefaultUpdateIntervalSec * time.Second) // Keeps the cache updated in the background
-	listenAddress := ":" + strconv.Itoa(port)
-	go server.Run(listenAddress) // Run the service in a separate thread to not block signal handler
+	return breakers, nil
+}
 
-	// Wait for shutdown signals
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+// Cache on top of the breakers from githubFlags.breakers, with the history size from NFCACHE_HISTORY_SIZE.
+func newCachedAPI(breakers []*apiclient.Breaker, store datasource.Store, logger *zap.SugaredLogger) (*datasource.CachedAPI, error) {
+	refreshClient := breakers[len(breakers)-1] // The proxy client unless refreshes have their own
+	apiCache := datasource.NewCachedAPIWithStore(breakers[0], refreshClient, store, logger)
+	if value := os.Getenv("NFCACHE_HISTORY_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid NFCACHE_HISTORY_SIZE %q", value)
+		}
+		apiCache.SetHistorySize(size)
+	}
+	return apiCache, nil
+}
+
+// Orgs to cache from GITHUB_ORGS, defaults to apiserver.DefaultOrg.
+func loadOrgs() []string {
+	githubOrgs := envList("GITHUB_ORGS")
+	if len(githubOrgs) == 0 {
+		githubOrgs = []string{apiserver.DefaultOrg}
+	}
+	return githubOrgs
+}
 
-	server.Shutdown(5 * time.Second)
-	apiCache.Shutdown() // Can take a bit if we're in the middle of a cache update
-	logger.Info("Service gracefully exited")
+// Split a comma separated env var, dropping empty entries.
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Health thresholds from NFCACHE_HEALTH_* env vars, unset ones are left to the defaults.
+func loadHealthOptions() (apiserver.HealthOptions, error) {
+	opts := apiserver.HealthOptions{Required: envList("NFCACHE_HEALTH_REQUIRED")}
+	for name, age := range map[string]*time.Duration{
+		"NFCACHE_HEALTH_DEGRADED_AGE": &opts.DegradedAge,
+		"NFCACHE_HEALTH_FAILING_AGE":  &opts.FailingAge,
+	} {
+		if value := os.Getenv(name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return opts, fmt.Errorf("%s: %w", name, err)
+			}
+			*age = duration
+		}
+	}
+	if value := os.Getenv("NFCACHE_HEALTH_DEGRADED_CODE"); value != "" {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return opts, fmt.Errorf("NFCACHE_HEALTH_DEGRADED_CODE: %w", err)
+		}
+		opts.DegradedCode = code
+	}
+	return opts, nil
+}
+
+// Github app auth is used when GITHUB_APP_ID is set, returns nil otherwise.
+func loadGithubApp() (*apiclient.GithubApp, error) {
+	appID := os.Getenv("GITHUB_APP_ID")
+	if appID == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"))
+	if err != nil {
+		return nil, err
+	}
+	return apiclient.NewGithubApp(apiclient.GithubAppOptions{
+		AppID:          appID,
+		InstallationID: os.Getenv("GITHUB_APP_INSTALLATION_ID"),
+		PrivateKey:     key,
+	})
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/fakegithub"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+// Cache on a temp snapshot file that fetches from a fake github with the Netflix org.
+func tCache(t *testing.T) (*datasource.CachedAPI, *datasource.DiskStore, *fakegithub.Server) {
+	fake := fakegithub.New()
+	t.Cleanup(fake.Close)
+	fake.AddOrg(fakegithub.Org{
+		Login:   "Netflix",
+		Members: fakegithub.GenerateMembers("Netflix", 3),
+		Repos:   fakegithub.GenerateRepos("Netflix", 5),
+	})
+	store, err := datasource.NewDiskStore(filepath.Join(t.TempDir(), "cache.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+	client := apiclient.NewGithubWithOptions(apiclient.GithubOptions{BaseURL: fake.URL})
+	return datasource.NewCachedAPIWithStore(client, client, store, zaptest.NewLogger(t).Sugar()), store, fake
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		args    []string
+		command string
+		rest    []string
+	}{
+		{nil, "serve", nil},
+		{[]string{"-p", "9090"}, "serve", []string{"-p", "9090"}},
+		{[]string{"warm", "-o", "cache.db"}, "warm", []string{"-o", "cache.db"}},
+		{[]string{"get", "/orgs/Netflix"}, "get", []string{"/orgs/Netflix"}},
+		{[]string{"help"}, "help", []string{}},
+	}
+	for _, tc := range cases {
+		command, rest := parseCommand(tc.args)
+		assert.Equal(t, tc.command, command, "%v", tc.args)
+		assert.Equal(t, tc.rest, rest, "%v", tc.args)
+	}
+}
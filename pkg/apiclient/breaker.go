@@ -0,0 +1,276 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Calls go through, outcomes are tracked
+	BreakerOpen                         // Calls fail fast with ErrCircuitOpen
+	BreakerHalfOpen                     // A few trial calls go through to see if upstream has recovered
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *BreakerState) UnmarshalText(text []byte) error {
+	for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown breaker state %q", text)
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Returned instead of calling upstream while the breaker is open. Matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	Name       string
+	RetryAfter time.Duration // Until the breaker lets a trial call through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s circuit breaker is open, retry in %v", e.Name, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Settings for a circuit breaker, zero values fall back to the defaults.
+type BreakerOptions struct {
+	Name             string        // For logs & metrics, defaults to "github"
+	WindowSize       int           // Number of recent calls the error rate is worked out over, defaults to 20
+	MinRequests      int           // Calls needed in the window before it can trip, defaults to 10
+	ErrorRate        float64       // Share of failed calls that trips the breaker, defaults to 0.5
+	SlowCall         time.Duration // Calls slower than this count as failures, defaults to half of DefaultTimeoutSec
+	OpenDuration     time.Duration // How long to fail fast before trying upstream again, defaults to 30s
+	HalfOpenRequests int           // Trial calls that must succeed to close again, defaults to 3
+
+	OnStateChange func(name string, from BreakerState, to BreakerState) // Optional, called with the lock held
+}
+
+// Counts kept by a breaker, see Breaker.Stats.
+type BreakerStats struct {
+	Name     string       `json:"name"`
+	State    BreakerState `json:"state"`
+	Requests int          `json:"window_requests"` // Calls in the current window
+	Failures int          `json:"window_failures"`
+	Rejected uint64       `json:"rejected"` // Calls failed fast since startup
+	Opened   uint64       `json:"opened"`   // Times the breaker has tripped since startup
+}
+
+// Circuit breaker around an api client. Trips open when too many recent calls fail or are
+// slow, so callers fail fast during an upstream outage instead of waiting out timeouts.
+// Conforms to the api client & status reporter interfaces. Safe for concurrent use.
+type Breaker struct {
+	client ApiClient
+	opts   BreakerOptions
+	now    func() time.Time // Swappable for tests
+
+	lock     *sync.Mutex
+	state    BreakerState
+	window   []bool // Ring of recent outcomes, true for a failure
+	next     int    // Where the next outcome goes in the window
+	failures int    // Failures currently in the window
+	openedAt time.Time
+	trials   int // Half-open calls let through
+	passed   int // Half-open calls that succeeded
+	rejected uint64
+	opened   uint64
+}
+
+func NewBreaker(client ApiClient, opts BreakerOptions) *Breaker {
+	if opts.Name == "" {
+		opts.Name = "github"
+	}
+	if opts.WindowSize <= 0 {
+		opts.WindowSize = 20
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.MinRequests > opts.WindowSize {
+		opts.MinRequests = opts.WindowSize
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = 0.5
+	}
+	if opts.SlowCall <= 0 {
+		opts.SlowCall = DefaultTimeoutSec * time.Second / 2
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 3
+	}
+	return &Breaker{
+		client: client,
+		opts:   opts,
+		now:    time.Now,
+		lock:   &sync.Mutex{},
+		window: make([]bool, 0, opts.WindowSize),
+	}
+}
+
+func (b *Breaker) Fetch(ctx context.Context, path string) ([]byte, error) {
+	return b.call(ctx, func() ([]byte, error) { return b.client.Fetch(ctx, path) })
+}
+
+func (b *Breaker) FetchAll(ctx context.Context, path string) ([]byte, error) {
+	return b.call(ctx, func() ([]byte, error) { return b.client.FetchAll(ctx, path) })
+}
+
+// Status of the wrapped client, zero if it doesn't report one.
+func (b *Breaker) Status() UpstreamStatus {
+	if reporter, ok := b.client.(StatusReporter); ok {
+		return reporter.Status()
+	}
+	return UpstreamStatus{}
+}
+
+func (b *Breaker) Name() string {
+	return b.opts.Name
+}
+
+func (b *Breaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.checkOpenTimeout()
+	return b.state
+}
+
+func (b *Breaker) Stats() BreakerStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.checkOpenTimeout()
+	return BreakerStats{
+		Name:     b.opts.Name,
+		State:    b.state,
+		Requests: len(b.window),
+		Failures: b.failures,
+		Rejected: b.rejected,
+		Opened:   b.opened,
+	}
+}
+
+func (b *Breaker) call(ctx context.Context, fetch func() ([]byte, error)) ([]byte, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	start := b.now()
+	data, err := fetch()
+	// The caller giving up says nothing about upstream
+	if !errors.Is(err, context.Canceled) {
+		b.record(err != nil || b.now().Sub(start) > b.opts.SlowCall)
+	} else {
+		b.release()
+	}
+	return data, err
+}
+
+// Caller must hold the lock.
+func (b *Breaker) checkOpenTimeout() {
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.opts.OpenDuration)) {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *Breaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.checkOpenTimeout()
+	switch b.state {
+	case BreakerOpen:
+		b.rejected++
+		return &CircuitOpenError{Name: b.opts.Name, RetryAfter: b.openedAt.Add(b.opts.OpenDuration).Sub(b.now())}
+	case BreakerHalfOpen:
+		if b.trials >= b.opts.HalfOpenRequests {
+			b.rejected++
+			return &CircuitOpenError{Name: b.opts.Name, RetryAfter: time.Second} // Trials should be done shortly
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// Give back a half-open trial slot for a call that didn't tell us anything.
+func (b *Breaker) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *Breaker) record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip()
+			return
+		}
+		b.passed++
+		if b.passed >= b.opts.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		if len(b.window) < b.opts.WindowSize {
+			b.window = append(b.window, failed)
+		} else {
+			if b.window[b.next] {
+				b.failures--
+			}
+			b.window[b.next] = failed
+			b.next = (b.next + 1) % b.opts.WindowSize
+		}
+		if failed {
+			b.failures++
+		}
+		if len(b.window) >= b.opts.MinRequests && float64(b.failures)/float64(len(b.window)) >= b.opts.ErrorRate {
+			b.trip()
+		}
+	}
+	// Calls that finish after the breaker opened are ignored
+}
+
+// Caller must hold the lock.
+func (b *Breaker) trip() {
+	b.openedAt = b.now()
+	b.opened++
+	b.setState(BreakerOpen)
+}
+
+// Every state starts with a clean slate. Caller must hold the lock.
+func (b *Breaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	b.window = b.window[:0]
+	b.next, b.failures, b.trials, b.passed = 0, 0, 0, 0
+	if from != state && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.opts.Name, from, state)
+	}
+}
@@ -0,0 +1,112 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/fakegithub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tBreaker(m *ApiClientMock) (*Breaker, *time.Time, *[]string) {
+	now := time.Now()
+	var changes []string
+	b := NewBreaker(m, BreakerOptions{
+		WindowSize:       4,
+		MinRequests:      4,
+		SlowCall:         time.Second,
+		OpenDuration:     10 * time.Second,
+		HalfOpenRequests: 2,
+		OnStateChange: func(name string, from BreakerState, to BreakerState) {
+			changes = append(changes, from.String()+">"+to.String())
+		},
+	})
+	b.now = func() time.Time { return now }
+	return b, &now, &changes
+}
+
+func TestBreakerTrips(t *testing.T) {
+	m := new(ApiClientMock)
+	b, now, changes := tBreaker(m)
+	ctx := context.Background()
+	m.On("Fetch", mock.Anything, "/ok").Return([]byte(`{}`), nil)
+	m.On("Fetch", mock.Anything, "/down").Return([]byte(nil), errors.New("timeout"))
+
+	b.Fetch(ctx, "/ok")
+	b.Fetch(ctx, "/down")
+	b.Fetch(ctx, "/ok")
+	assert.Equal(t, BreakerClosed, b.State(), "not enough calls to judge yet")
+	b.Fetch(ctx, "/down")
+	assert.Equal(t, BreakerOpen, b.State(), "half the window failed")
+
+	_, err := b.Fetch(ctx, "/ok")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	var openErr *CircuitOpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.Equal(t, 10*time.Second, openErr.RetryAfter)
+	m.AssertNumberOfCalls(t, "Fetch", 4) // Failed fast
+	assert.Equal(t, uint64(1), b.Stats().Rejected)
+
+	// Trial calls once the open period is up, a failure reopens it
+	*now = now.Add(10 * time.Second)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	_, err = b.Fetch(ctx, "/down")
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	*now = now.Add(10 * time.Second)
+	b.Fetch(ctx, "/ok")
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	b.Fetch(ctx, "/ok")
+	assert.Equal(t, BreakerClosed, b.State(), "enough trials passed")
+	assert.Equal(t, []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}, *changes)
+	assert.Equal(t, uint64(2), b.Stats().Opened)
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	m := new(ApiClientMock)
+	b, now, _ := tBreaker(m)
+	m.On("FetchAll", mock.Anything, "/slow").Run(func(mock.Arguments) {
+		*now = now.Add(2 * time.Second)
+	}).Return([]byte(`[]`), nil)
+
+	for i := 0; i < 4; i++ {
+		data, err := b.FetchAll(context.Background(), "/slow")
+		assert.Nil(t, err, "slow calls still return their data")
+		assert.Equal(t, `[]`, string(data))
+	}
+	assert.Equal(t, BreakerOpen, b.State(), "slow calls count as failures")
+}
+
+func TestBreakerIgnoresCancellation(t *testing.T) {
+	m := new(ApiClientMock)
+	b, _, _ := tBreaker(m)
+	m.On("Fetch", mock.Anything, "/").Return([]byte(nil), context.Canceled)
+	for i := 0; i < 4; i++ {
+		b.Fetch(context.Background(), "/")
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, 0, b.Stats().Requests)
+}
+
+func TestBreakerTripsOnServerErrors(t *testing.T) {
+	fake := fakegithub.New()
+	defer fake.Close()
+	fake.AddOrg(fakegithub.Org{Login: "Netflix"})
+	fake.SetError("/orgs/Netflix/repos", http.StatusBadGateway)
+	b := NewBreaker(NewGithubWithOptions(GithubOptions{BaseURL: fake.URL}), BreakerOptions{WindowSize: 4, MinRequests: 4})
+
+	for i := 0; i < 4; i++ {
+		_, err := b.FetchAll(context.Background(), "/orgs/Netflix/repos")
+		var statusErr *StatusError
+		assert.ErrorAs(t, err, &statusErr)
+	}
+	assert.Equal(t, BreakerOpen, b.State(), "fast 502s count as failures")
+	_, err := b.FetchAll(context.Background(), "/orgs/Netflix/repos")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 4, fake.RequestCount("/orgs/Netflix/repos"))
+}
@@ -9,6 +9,14 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/njo/nfcache/pkg/requestid"
+	"github.com/njo/nfcache/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 const (
@@ -19,24 +27,63 @@ const (
 	PerPageDefault    = 100
 )
 
-// Conforms to the api client interface. Can be used concurrently.
+// Conforms to the api client & status reporter interfaces. Can be used concurrently.
 type GithubClient struct {
-	apiKey string
-	client *http.Client
+	baseURL string
+	tokens  TokenSource
+	client  *http.Client
+	log     *zap.SugaredLogger
+	status  *statusTracker
+}
+
+// Supplies the token for each request. Implementations must be safe for concurrent use.
+type TokenSource interface {
+	Token(context.Context) (string, error)    // Token to send with the next request, "" for none
+	Observe(token string, res *http.Response) // Called with each response for the token that was sent
+}
+
+// Optional settings for a github client, zero values fall back to the defaults.
+type GithubOptions struct {
+	BaseURL    string             // Defaults to GithubApiURL, override for enterprise servers or testing
+	Tokens     TokenSource        // Defaults to unauthenticated requests
+	HttpClient *http.Client       // Defaults to a client with a DefaultTimeoutSec timeout
+	Logger     *zap.SugaredLogger // Logs each upstream request at debug & failures at warn, defaults to no logging
 }
 
 func NewGithub(apiKey string) ApiClient {
-	client := http.Client{Timeout: DefaultTimeoutSec * time.Second}
-	return NewGithubWithHttpClient(apiKey, &client)
+	return NewGithubWithOptions(GithubOptions{Tokens: NewTokenPool(apiKey)})
 }
 
 // For when the caller wants to tune their own http client (or use a mock in testing).
 func NewGithubWithHttpClient(apiKey string, client *http.Client) ApiClient {
-	return &GithubClient{apiKey, client}
+	return NewGithubWithOptions(GithubOptions{Tokens: NewTokenPool(apiKey), HttpClient: client})
 }
 
+func NewGithubWithOptions(opts GithubOptions) ApiClient {
+	if opts.BaseURL == "" {
+		opts.BaseURL = GithubApiURL
+	}
+	if opts.Tokens == nil {
+		opts.Tokens = NewTokenPool()
+	}
+	if opts.HttpClient == nil {
+		opts.HttpClient = &http.Client{Timeout: DefaultTimeoutSec * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop().Sugar()
+	}
+	return &GithubClient{baseURL: opts.BaseURL, tokens: opts.Tokens, client: opts.HttpClient, log: opts.Logger, status: newStatusTracker()}
+}
+
+// Reachability & rate limit budget as of the latest request.
+func (g *GithubClient) Status() UpstreamStatus {
+	return g.status.Status()
+}
+
+// The path can include an encoded query string, e.g. /search/repositories?q=foo
 func (g *GithubClient) createRequest(ctx context.Context, path string) (*http.Request, error) {
-	fullUrl, err := url.JoinPath(GithubApiURL, path)
+	path, rawQuery, _ := strings.Cut(path, "?")
+	fullUrl, err := url.JoinPath(g.baseURL, path)
 	if err != nil {
 		return nil, err
 	}
@@ -45,22 +92,70 @@ func (g *GithubClient) createRequest(ctx context.Context, path string) (*http.Re
 	if err != nil {
 		return nil, err
 	}
-
-	if g.apiKey != "" {
-		req.Header.Set("Authorization", "token "+g.apiKey)
-	}
+	req.URL.RawQuery = rawQuery
 	req.Header.Set("User-Agent", UserAgent)
 
 	return req, nil
 }
 
+// Send the request with a token from the token source, then let it know how the request went.
+// Server errors & throttling come back as a *StatusError, other error statuses are returned as responses.
+// Each request gets its own span and passes the trace on to github.
+func (g *GithubClient) do(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), "GithubClient.request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(req.Method), semconv.HTTPURL(req.URL.String())))
+	defer span.End()
+	if page := req.URL.Query().Get("page"); page != "" {
+		span.SetAttributes(attribute.String("github.page", page))
+	}
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+
+	token, err := g.tokens.Token(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	log := requestid.Logger(req.Context(), g.log).With("method", req.Method, "url", req.URL.String())
+	start := time.Now()
+	res, err := g.client.Do(req)
+	if ctx.Err() == nil { // Our own timeouts & cancellations say nothing about github
+		g.status.observe(res, err)
+	}
+	if err != nil {
+		log.Warnw("Github request failed", "duration_ms", time.Since(start).Milliseconds(), "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCode(res.StatusCode))
+	if res.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	fields := []any{"status", res.StatusCode, "duration_ms", time.Since(start).Milliseconds(),
+		"rate_limit_remaining", res.Header.Get(HeaderRateLimitRemaining)}
+	g.tokens.Observe(token, res)
+	if err := statusError(res); err != nil {
+		log.Warnw("Github request failed", append(fields, "error", err)...)
+		res.Body.Close()
+		return nil, err
+	}
+	log.Debugw("Github request", fields...) // One per page, too noisy for info
+	return res, nil
+}
+
 func (g *GithubClient) Fetch(ctx context.Context, path string) ([]byte, error) {
 	req, err := g.createRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := g.client.Do(req)
+	res, err := g.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -69,15 +164,21 @@ func (g *GithubClient) Fetch(ctx context.Context, path string) ([]byte, error) {
 }
 
 func (g *GithubClient) FetchAll(ctx context.Context, path string) ([]byte, error) {
+	pageCount := 1
+	ctx, span := tracing.Tracer().Start(ctx, "GithubClient.FetchAll", trace.WithAttributes(attribute.String("github.path", path)))
+	defer func() {
+		span.SetAttributes(attribute.Int("github.pages", pageCount))
+		span.End()
+	}()
+
 	req, err := g.createRequest(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	pageCount := 1
 	setRequestPagination(req, PerPageDefault, pageCount)
 
-	res, err := g.client.Do(req)
+	res, err := g.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +214,7 @@ func (g *GithubClient) FetchAll(ctx context.Context, path string) ([]byte, error
 	for responseHasNext(res) && pageCount < MaxPageFollow {
 		pageCount = pageCount + 1
 		setRequestPagination(req, PerPageDefault, pageCount)
-		res, err = g.client.Do(req)
+		res, err = g.do(req)
 		if err != nil {
 			return nil, err
 		}
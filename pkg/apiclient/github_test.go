@@ -0,0 +1,167 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/fakegithub"
+	"github.com/njo/nfcache/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCreateRequest(t *testing.T) {
+	g := NewGithub("key").(*GithubClient)
+	ctx := context.Background()
+
+	req, err := g.createRequest(ctx, "/orgs/Netflix/repos")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.github.com/orgs/Netflix/repos", req.URL.String())
+	assert.Equal(t, UserAgent, req.Header.Get("User-Agent"))
+
+	req, err = g.createRequest(ctx, "/search/repositories?q=org%3ANetflix+cassandra&sort=stars")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.github.com/search/repositories?q=org%3ANetflix+cassandra&sort=stars", req.URL.String())
+	assert.Equal(t, "org:Netflix cassandra", req.URL.Query().Get("q"))
+
+	// Pagination keeps the rest of the query
+	setRequestPagination(req, 100, 2)
+	assert.Equal(t, "org:Netflix cassandra", req.URL.Query().Get("q"))
+	assert.Equal(t, "2", req.URL.Query().Get("page"))
+}
+
+func TestRequestLogging(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimitRemaining, "42")
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	core, logs := observer.New(zapcore.DebugLevel)
+	g := NewGithubWithOptions(GithubOptions{BaseURL: srv.URL, Logger: zap.New(core).Sugar()})
+
+	_, err := g.Fetch(requestid.NewContext(context.Background(), "abc"), "/orgs/Netflix")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, zapcore.DebugLevel, logs.All()[0].Level, "a line per page is too much for info")
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "abc", fields[requestid.LogField], "upstream calls are tagged with the request id")
+	assert.Equal(t, srv.URL+"/orgs/Netflix", fields["url"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Equal(t, "42", fields["rate_limit_remaining"])
+
+	status = http.StatusBadGateway
+	_, err = g.Fetch(context.Background(), "/orgs/Netflix")
+	assert.NotNil(t, err)
+	failures := logs.FilterLevelExact(zapcore.WarnLevel).All()
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, int64(http.StatusBadGateway), failures[0].ContextMap()["status"])
+	}
+}
+
+func TestUpstreamStatus(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimitLimit, "5000")
+		w.Header().Set(HeaderRateLimitRemaining, "4321")
+		w.Header().Set(HeaderRateLimitReset, "1700000000")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	g := NewGithubWithOptions(GithubOptions{BaseURL: srv.URL}).(*GithubClient)
+	assert.True(t, g.Status().Reachable(), "nothing has failed yet")
+
+	_, err := g.Fetch(context.Background(), "/")
+	assert.Nil(t, err)
+	assert.True(t, g.Status().Reachable())
+	assert.Equal(t, &RateLimit{Limit: 5000, Remaining: 4321, Reset: time.Unix(1700000000, 0).UTC()}, g.Status().RateLimit)
+
+	status = http.StatusBadGateway
+	_, err = g.Fetch(context.Background(), "/")
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr, "server errors aren't passed back as data")
+	assert.Equal(t, http.StatusBadGateway, statusErr.StatusCode)
+	assert.False(t, g.Status().Reachable())
+	assert.Equal(t, "502 Bad Gateway", g.Status().LastError)
+
+	// Client errors still mean github is up
+	status = http.StatusNotFound
+	_, err = g.Fetch(context.Background(), "/")
+	assert.Nil(t, err)
+	assert.True(t, g.Status().Reachable())
+}
+
+func TestGithubAgainstFake(t *testing.T) {
+	fake := fakegithub.New()
+	defer fake.Close()
+	fake.AddOrg(fakegithub.Org{Login: "Netflix", ID: 913567, Repos: fakegithub.GenerateRepos("Netflix", 250)})
+	fake.RequireTokens("good")
+	g := NewGithubWithOptions(GithubOptions{BaseURL: fake.URL, Tokens: NewTokenPool("good")})
+	ctx := context.Background()
+
+	body, err := g.FetchAll(ctx, "/orgs/Netflix/repos")
+	assert.Nil(t, err)
+	var repos []fakegithub.Repo
+	assert.Nil(t, json.Unmarshal(body, &repos))
+	assert.Equal(t, fakegithub.GenerateRepos("Netflix", 250), repos, "pages are joined in order")
+	assert.Equal(t, 3, fake.RequestCount("/orgs/Netflix/repos"))
+	for _, req := range fake.Requests() {
+		assert.Equal(t, "good", req.Token)
+	}
+
+	// Stops following links after MaxPageFollow pages
+	fake.SetMaxPerPage(1)
+	fake.AddOrg(fakegithub.Org{Login: "big", Repos: fakegithub.GenerateRepos("big", MaxPageFollow+5)})
+	body, err = g.FetchAll(ctx, "/orgs/big/repos")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(body, &repos))
+	assert.Len(t, repos, MaxPageFollow)
+
+	// Github's error bodies are passed back as is, the status tracker is what notices them
+	bad := NewGithubWithOptions(GithubOptions{BaseURL: fake.URL, Tokens: NewTokenPool("bad")})
+	body, err = bad.Fetch(ctx, "/orgs/Netflix")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"message": "Bad credentials"}`, string(body))
+
+	fake.SetLatency(time.Second)
+	slow := NewGithubWithOptions(GithubOptions{BaseURL: fake.URL, Tokens: NewTokenPool("good"), HttpClient: &http.Client{Timeout: 20 * time.Millisecond}})
+	_, err = slow.Fetch(ctx, "/orgs/Netflix")
+	assert.NotNil(t, err, "timed out")
+	assert.False(t, slow.(*GithubClient).Status().Reachable())
+}
+
+func TestStatusError(t *testing.T) {
+	for _, test := range []struct {
+		status     int
+		retryAfter string
+		failed     bool
+		wait       time.Duration
+	}{
+		{http.StatusOK, "", false, 0},
+		{http.StatusNotFound, "", false, 0},
+		{http.StatusForbidden, "", false, 0},
+		{http.StatusForbidden, "60", true, time.Minute}, // Secondary rate limit
+		{http.StatusTooManyRequests, "", true, 0},
+		{http.StatusServiceUnavailable, "5", true, 5 * time.Second},
+	} {
+		res := &http.Response{StatusCode: test.status, Status: http.StatusText(test.status), Header: http.Header{}}
+		if test.retryAfter != "" {
+			res.Header.Set(HeaderRetryAfter, test.retryAfter)
+		}
+		err := statusError(res)
+		if !test.failed {
+			assert.Nil(t, err, "%d", test.status)
+			continue
+		}
+		if assert.NotNil(t, err, "%d", test.status) {
+			assert.Equal(t, test.wait, err.RetryAfter)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package apiclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	AppJWTLifetime   = 9 * time.Minute // Github rejects app JWTs that live longer than 10 minutes
+	AppJWTClockSkew  = time.Minute     // Backdate iat in case our clock is ahead of github's
+	AppTokenRenewal  = 5 * time.Minute // Renew installation tokens this long before they expire
+	AppTokenMaxBytes = 1 << 20
+)
+
+// Settings to authenticate as a github app installation.
+type GithubAppOptions struct {
+	AppID          string
+	InstallationID string
+	PrivateKey     []byte       // PEM encoded, PKCS#1 as downloaded from github or PKCS#8
+	BaseURL        string       // Defaults to GithubApiURL
+	HttpClient     *http.Client // Defaults to a client with a DefaultTimeoutSec timeout
+}
+
+// Token source that authenticates as a github app. Signs a JWT with the app's private key
+// and exchanges it for an installation token, which is cached until it's close to expiring.
+// Conforms to the token source interface. Safe for concurrent use.
+type GithubApp struct {
+	appID    string
+	tokenURL string
+	key      *rsa.PrivateKey
+	client   *http.Client
+
+	lock      *sync.Mutex // Held during exchanges so concurrent requests share one
+	token     string
+	expiresAt time.Time
+	now       func() time.Time // Swappable for tests
+}
+
+func NewGithubApp(opts GithubAppOptions) (*GithubApp, error) {
+	if opts.AppID == "" || opts.InstallationID == "" {
+		return nil, errors.New("github app id and installation id are required")
+	}
+	key, err := parsePrivateKey(opts.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = GithubApiURL
+	}
+	if opts.HttpClient == nil {
+		opts.HttpClient = &http.Client{Timeout: DefaultTimeoutSec * time.Second}
+	}
+	tokenURL, err := url.JoinPath(opts.BaseURL, "app/installations", opts.InstallationID, "access_tokens")
+	if err != nil {
+		return nil, err
+	}
+	return &GithubApp{
+		appID:    opts.AppID,
+		tokenURL: tokenURL,
+		key:      key,
+		client:   opts.HttpClient,
+		lock:     &sync.Mutex{},
+		now:      time.Now,
+	}, nil
+}
+
+// The current installation token, exchanging for a new one if it's missing or about to expire.
+func (a *GithubApp) Token(ctx context.Context) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.token != "" && a.now().Before(a.expiresAt.Add(-AppTokenRenewal)) {
+		return a.token, nil
+	}
+	token, expiresAt, err := a.exchange(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to get github app installation token: %w", err)
+	}
+	a.token, a.expiresAt = token, expiresAt
+	return a.token, nil
+}
+
+// Installation tokens have their own quota, there's nothing to pick between.
+func (a *GithubApp) Observe(token string, res *http.Response) {}
+
+// Swap a freshly signed JWT for an installation token.
+func (a *GithubApp) exchange(ctx context.Context) (string, time.Time, error) {
+	jwt, err := a.signJWT()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", UserAgent)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, AppTokenMaxBytes))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("token exchange returned %d: %s", res.StatusCode, body)
+	}
+
+	var installation struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &installation); err != nil {
+		return "", time.Time{}, err
+	}
+	if installation.Token == "" {
+		return "", time.Time{}, errors.New("token exchange response has no token")
+	}
+	return installation.Token, installation.ExpiresAt, nil
+}
+
+// RS256 JWT identifying the app, see
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (a *GithubApp) signJWT() (string, error) {
+	now := a.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-AppJWTClockSkew).Unix(),
+		"exp": now.Add(AppJWTLifetime).Unix(),
+		"iss": a.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+func parsePrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("github app private key isn't PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse github app private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key isn't an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,140 @@
+package apiclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stand-in for github's installation token endpoint. Checks the app JWT and hands out numbered tokens.
+type tokenEndpoint struct {
+	t         *testing.T
+	key       *rsa.PublicKey
+	lifetime  time.Duration
+	lock      sync.Mutex
+	exchanges int
+}
+
+func (e *tokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if rsa.VerifyPKCS1v15(e.key, crypto.SHA256, digest[:], signature) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	assert.Nil(e.t, json.Unmarshal(claimsJSON, &claims))
+	assert.Equal(e.t, "1234", claims.Iss)
+	assert.LessOrEqual(e.t, claims.Exp-claims.Iat, int64(10*time.Minute/time.Second))
+
+	e.exchanges++
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token":"ghs_%d","expires_at":"%s"}`, e.exchanges, time.Now().Add(e.lifetime).UTC().Format(time.RFC3339))
+}
+
+func tAppKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestGithubAppTokens(t *testing.T) {
+	key, keyPEM := tAppKey(t)
+	endpoint := &tokenEndpoint{t: t, key: &key.PublicKey, lifetime: time.Hour}
+	srv := httptest.NewServer(endpoint)
+	defer srv.Close()
+
+	app, err := NewGithubApp(GithubAppOptions{AppID: "1234", InstallationID: "42", PrivateKey: keyPEM, BaseURL: srv.URL})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	token, err := app.Token(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "ghs_1", token)
+
+	token, _ = app.Token(ctx)
+	assert.Equal(t, "ghs_1", token, "tokens are cached")
+	assert.Equal(t, 1, endpoint.exchanges)
+
+	// Renewed once the token is within the renewal window
+	app.now = func() time.Time { return time.Now().Add(time.Hour - AppTokenRenewal + time.Second) }
+	token, _ = app.Token(ctx)
+	assert.Equal(t, "ghs_2", token)
+	assert.Equal(t, 2, endpoint.exchanges)
+}
+
+func TestGithubAppClient(t *testing.T) {
+	key, keyPEM := tAppKey(t)
+	endpoint := &tokenEndpoint{t: t, key: &key.PublicKey, lifetime: time.Hour}
+	var authorization string
+	mux := http.NewServeMux()
+	mux.Handle("/app/", endpoint)
+	mux.HandleFunc("/orgs/Netflix", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"login":"Netflix"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	app, err := NewGithubApp(GithubAppOptions{AppID: "1234", InstallationID: "42", PrivateKey: keyPEM, BaseURL: srv.URL})
+	assert.Nil(t, err)
+	g := NewGithubWithOptions(GithubOptions{BaseURL: srv.URL, Tokens: app})
+	body, err := g.Fetch(context.Background(), "/orgs/Netflix")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"login":"Netflix"}`, string(body))
+	assert.Equal(t, "token ghs_1", authorization)
+}
+
+func TestGithubAppErrors(t *testing.T) {
+	_, keyPEM := tAppKey(t)
+	_, err := NewGithubApp(GithubAppOptions{AppID: "1234", PrivateKey: keyPEM})
+	assert.NotNil(t, err, "installation id is required")
+
+	_, err = NewGithubApp(GithubAppOptions{AppID: "1234", InstallationID: "42", PrivateKey: []byte("not a key")})
+	assert.NotNil(t, err)
+
+	// A key github doesn't know about gets rejected by the exchange
+	other, _ := tAppKey(t)
+	endpoint := &tokenEndpoint{t: t, key: &other.PublicKey, lifetime: time.Hour}
+	srv := httptest.NewServer(endpoint)
+	defer srv.Close()
+	app, err := NewGithubApp(GithubAppOptions{AppID: "1234", InstallationID: "42", PrivateKey: keyPEM, BaseURL: srv.URL})
+	assert.Nil(t, err)
+	_, err = app.Token(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
@@ -0,0 +1,154 @@
+package apiclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNoFixture = errors.New("no recorded fixture")
+
+// A single upstream request & response as saved to a fixtures dir. Paginated fetches get one per page.
+// Request headers aren't kept so tokens never end up in fixtures.
+type Exchange struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"` // Path & query, the host is left out so fixtures replay against any base url with the same path
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// Github client that saves every upstream exchange, headers & all, to the fixtures dir as it goes.
+// Wraps the transport of the options' http client so pagination & status tracking see the real responses.
+func NewRecording(dir string, opts GithubOptions) (ApiClient, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: DefaultTimeoutSec * time.Second}
+	if opts.HttpClient != nil {
+		copied := *opts.HttpClient
+		client = &copied
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &recordingTransport{dir: dir, next: next}
+	opts.HttpClient = client
+	return NewGithubWithOptions(opts), nil
+}
+
+// Github client that serves exchanges saved by NewRecording without touching the network.
+// Requests without a fixture fail with ErrNoFixture. The options' http client is ignored.
+func NewReplay(dir string, opts GithubOptions) (ApiClient, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fixtures path %s isn't a directory", dir)
+	}
+	opts.HttpClient = &http.Client{Transport: &replayTransport{dir: dir}}
+	return NewGithubWithOptions(opts), nil
+}
+
+// Fixture file for a request, readable enough to find by eye. The hash tells pages & queries apart.
+func fixtureName(method string, key string) string {
+	sum := sha256.Sum256([]byte(method + " " + key))
+	path, _, _ := strings.Cut(key, "?")
+	slug := strings.ReplaceAll(strings.Trim(path, "/"), "/", "_")
+	return method + "_" + slug + "_" + hex.EncodeToString(sum[:4]) + ".json"
+}
+
+// Query params are sorted so the same request always maps to the same fixture.
+func fixtureKey(req *http.Request) string {
+	key := req.URL.EscapedPath()
+	if query := req.URL.Query(); len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err // Nothing to replay
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(strings.NewReader(string(body)))
+
+	key := fixtureKey(req)
+	exchange := Exchange{Method: req.Method, URL: key, Status: res.StatusCode, Header: res.Header, Body: string(body)}
+	if err := writeFixture(filepath.Join(t.dir, fixtureName(req.Method, key)), exchange); err != nil {
+		return nil, fmt.Errorf("unable to record %s %s: %w", req.Method, key, err)
+	}
+	return res, nil
+}
+
+// Write to a temp file & rename it into place so concurrent recordings of a path can't interleave.
+func writeFixture(path string, exchange Exchange) error {
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := fixtureKey(req)
+	data, err := os.ReadFile(filepath.Join(t.dir, fixtureName(req.Method, key)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoFixture, req.Method, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var exchange Exchange
+	if err := json.Unmarshal(data, &exchange); err != nil {
+		return nil, fmt.Errorf("invalid fixture for %s %s: %w", req.Method, key, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          io.NopCloser(strings.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
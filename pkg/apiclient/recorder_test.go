@@ -0,0 +1,58 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimitLimit, "5000")
+		w.Header().Set(HeaderRateLimitRemaining, "4321")
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("Link", `<https://api.github.com/orgs/Netflix/members?page=2>; rel="next"`)
+			w.Write([]byte(`[{"login": "a"}]`))
+			return
+		}
+		w.Write([]byte(`[{"login": "b"}]`))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	recording, err := NewRecording(dir, GithubOptions{BaseURL: srv.URL, Tokens: NewTokenPool("secret")})
+	assert.Nil(t, err)
+
+	body, err := recording.FetchAll(context.Background(), "/orgs/Netflix/members")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"login": "a"}, {"login": "b"}]`, string(body))
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2, "one fixture per page")
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		assert.False(t, strings.Contains(string(data), "secret"), "tokens aren't recorded")
+	}
+
+	srv.Close()
+	replay, err := NewReplay(dir, GithubOptions{}) // Fixtures replay against any host
+	assert.Nil(t, err)
+	body, err = replay.FetchAll(context.Background(), "/orgs/Netflix/members")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"login": "a"}, {"login": "b"}]`, string(body), "pagination follows the recorded link headers")
+	rateLimit := replay.(*GithubClient).Status().RateLimit
+	if assert.NotNil(t, rateLimit) {
+		assert.Equal(t, 4321, rateLimit.Remaining)
+	}
+
+	_, err = replay.Fetch(context.Background(), "/orgs/Netflix/repos")
+	assert.ErrorIs(t, err, ErrNoFixture)
+	_, err = NewReplay(filepath.Join(dir, "missing"), GithubOptions{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,124 @@
+package apiclient
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderRateLimitLimit = "X-RateLimit-Limit"
+	HeaderRetryAfter     = "Retry-After"
+)
+
+// Github answered, but with a server error or by throttling us (a 429, or a 403 with a
+// Retry-After for its secondary rate limits). Returned instead of the body so breakers
+// & the cache treat it as a failure rather than data.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // 0 if github didn't say
+}
+
+func (e *StatusError) Error() string {
+	return "github returned " + e.Status
+}
+
+// The error for a response that means github is struggling or throttling us, nil for anything else.
+func statusError(res *http.Response) *StatusError {
+	retryAfter := res.Header.Get(HeaderRetryAfter)
+	switch {
+	case res.StatusCode >= http.StatusInternalServerError:
+	case res.StatusCode == http.StatusTooManyRequests:
+	case res.StatusCode == http.StatusForbidden && retryAfter != "":
+	default:
+		return nil
+	}
+	err := &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	if seconds, parseErr := strconv.Atoi(retryAfter); parseErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// Clients that keep track of how upstream is doing, e.g. for health checks.
+type StatusReporter interface {
+	Status() UpstreamStatus
+}
+
+// What a client last saw of upstream. Times are zero until there's been a call.
+type UpstreamStatus struct {
+	LastSuccess time.Time  `json:"last_success"`
+	LastFailure time.Time  `json:"last_failure"`
+	LastError   string     `json:"last_error,omitempty"`
+	RateLimit   *RateLimit `json:"rate_limit,omitempty"` // From the latest response with rate limit headers
+}
+
+// Upstream answered the most recent call, or there hasn't been a failure yet.
+func (s UpstreamStatus) Reachable() bool {
+	return s.LastFailure.IsZero() || s.LastSuccess.After(s.LastFailure)
+}
+
+// Quota for the token used on the latest response.
+type RateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Safe for concurrent use.
+type statusTracker struct {
+	lock   *sync.Mutex
+	status UpstreamStatus
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{lock: &sync.Mutex{}}
+}
+
+func (t *statusTracker) Status() UpstreamStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := t.status
+	if status.RateLimit != nil {
+		limit := *status.RateLimit
+		status.RateLimit = &limit
+	}
+	return status
+}
+
+// Transport errors and server errors count as failures, anything else means upstream is up.
+func (t *statusTracker) observe(res *http.Response, err error) {
+	now := time.Now().UTC()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	switch {
+	case err != nil:
+		t.status.LastFailure, t.status.LastError = now, err.Error()
+		return
+	case res.StatusCode >= http.StatusInternalServerError:
+		t.status.LastFailure, t.status.LastError = now, res.Status
+	default:
+		t.status.LastSuccess = now
+	}
+	if limit, ok := parseRateLimit(res.Header); ok {
+		t.status.RateLimit = &limit
+	}
+}
+
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, err := strconv.Atoi(header.Get(HeaderRateLimitLimit))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get(HeaderRateLimitRemaining))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	rateLimit := RateLimit{Limit: limit, Remaining: remaining}
+	if epoch, err := strconv.ParseInt(header.Get(HeaderRateLimitReset), 10, 64); err == nil {
+		rateLimit.Reset = time.Unix(epoch, 0).UTC()
+	}
+	return rateLimit, true
+}
@@ -0,0 +1,101 @@
+package apiclient
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// Quota state for a single token, updated from the rate limit headers on each response.
+type tokenState struct {
+	token     string
+	remaining int       // -1 until we've seen a response for the token
+	reset     time.Time // When the quota window resets
+}
+
+// Requests left on the token right now, unknown quotas are assumed to be full.
+func (t *tokenState) available(now time.Time) int {
+	if t.remaining < 0 || (!t.reset.IsZero() && now.After(t.reset)) {
+		return math.MaxInt
+	}
+	return t.remaining
+}
+
+// A set of api tokens that picks the one with the most quota left for each request.
+// Conforms to the token source interface. Safe for concurrent use.
+type TokenPool struct {
+	tokens []*tokenState
+	lock   *sync.Mutex
+}
+
+// Empty tokens are skipped. A pool with no tokens makes unauthenticated requests.
+func NewTokenPool(tokens ...string) *TokenPool {
+	pool := &TokenPool{lock: &sync.Mutex{}}
+	for _, token := range tokens {
+		if token != "" {
+			pool.tokens = append(pool.tokens, &tokenState{token: token, remaining: -1})
+		}
+	}
+	return pool
+}
+
+func (p *TokenPool) Len() int {
+	return len(p.tokens)
+}
+
+// TokenSource for the github client, never fails. Returns "" for an empty pool.
+func (p *TokenPool) Token(ctx context.Context) (string, error) {
+	return p.next(), nil
+}
+
+// Pick the token with the most requests remaining. When every token is exhausted
+// the one that resets soonest is used so the request has the best chance of succeeding.
+func (p *TokenPool) next() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	var best *tokenState
+	for _, t := range p.tokens {
+		if best == nil {
+			best = t
+			continue
+		}
+		available, bestAvailable := t.available(now), best.available(now)
+		if available > bestAvailable || (available == 0 && bestAvailable == 0 && t.reset.Before(best.reset)) {
+			best = t
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.token
+}
+
+// Record the quota github reported for the token that made the request.
+func (p *TokenPool) Observe(token string, res *http.Response) {
+	remaining, err := strconv.Atoi(res.Header.Get(HeaderRateLimitRemaining))
+	if err != nil {
+		return // Not every response has rate limit headers
+	}
+	var reset time.Time
+	if epoch, err := strconv.ParseInt(res.Header.Get(HeaderRateLimitReset), 10, 64); err == nil {
+		reset = time.Unix(epoch, 0)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, t := range p.tokens {
+		if t.token == token {
+			t.remaining, t.reset = remaining, reset
+			return
+		}
+	}
+}
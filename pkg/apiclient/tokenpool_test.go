@@ -0,0 +1,82 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake github that tracks a quota per token and reports it in the rate limit headers.
+type quotaServer struct {
+	lock      sync.Mutex
+	remaining map[string]int
+	reset     map[string]time.Time
+	used      []string // Token used for each request in order
+}
+
+func (q *quotaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+	q.used = append(q.used, token)
+	if token == "" {
+		w.Write([]byte(`{}`))
+		return
+	}
+	if q.remaining[token] > 0 {
+		q.remaining[token]--
+	}
+	w.Header().Set(HeaderRateLimitRemaining, strconv.Itoa(q.remaining[token]))
+	w.Header().Set(HeaderRateLimitReset, strconv.FormatInt(q.reset[token].Unix(), 10))
+	w.Write([]byte(`{}`))
+}
+
+func TestTokenPoolRotation(t *testing.T) {
+	now := time.Now()
+	quotas := &quotaServer{
+		remaining: map[string]int{"a": 3, "b": 5},
+		reset:     map[string]time.Time{"a": now.Add(time.Hour), "b": now.Add(time.Minute)},
+	}
+	srv := httptest.NewServer(quotas)
+	defer srv.Close()
+
+	g := NewGithubWithOptions(GithubOptions{BaseURL: srv.URL, Tokens: NewTokenPool("a", "", "b")})
+	for i := 0; i < 9; i++ {
+		_, err := g.Fetch(context.Background(), "/orgs/Netflix")
+		assert.Nil(t, err)
+	}
+
+	expected := []string{
+		"a",      // Nothing known yet, first token wins
+		"b",      // Unknown quota is assumed to be full
+		"b", "b", // b has more left than a
+		"a", "b", "a", "b", // Ties go to the first token
+		"b", // Both exhausted, b resets first
+	}
+	assert.Equal(t, expected, quotas.used)
+}
+
+func TestTokenPoolResetWindow(t *testing.T) {
+	pool := NewTokenPool("a", "b")
+	pool.tokens[0].remaining, pool.tokens[0].reset = 0, time.Now().Add(-time.Second)
+	pool.tokens[1].remaining, pool.tokens[1].reset = 10, time.Now().Add(time.Hour)
+	assert.Equal(t, "a", pool.next(), "quota is assumed full once the reset time passes")
+}
+
+func TestTokenPoolUnauthenticated(t *testing.T) {
+	quotas := &quotaServer{}
+	srv := httptest.NewServer(quotas)
+	defer srv.Close()
+
+	g := NewGithubWithOptions(GithubOptions{BaseURL: srv.URL})
+	_, err := g.Fetch(context.Background(), "/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{""}, quotas.used, "no Authorization header without tokens")
+}
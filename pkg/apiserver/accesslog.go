@@ -0,0 +1,93 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Key the authenticated client's name is stored under in the gin context.
+const ctxClientName = "nfcache.client"
+
+// Query params whose values are kept out of the logs.
+var sensitiveQueryParams = []string{"access_token", "client_id", "client_secret"}
+
+// Tags each request with an ID, taken from X-Request-ID or generated, and logs it once handled.
+// The ID is echoed in the response and carried in the request context for the cache & api client.
+func accessLog(log *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := requestid.FromHeader(c.GetHeader(requestid.Header))
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []any{
+			requestid.LogField, id,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"query", redactQuery(c.Request.URL.RawQuery),
+			"route", c.FullPath(), // "" for proxied requests
+			"status", status,
+			"bytes", c.Writer.Size(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			fields = append(fields, "trace_id", span.TraceID().String())
+		}
+		if client, ok := c.Get(ctxClientName); ok {
+			fields = append(fields, "client", client)
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+		if status >= http.StatusInternalServerError {
+			log.Warnw("Request", fields...)
+			return
+		}
+		log.Infow("Request", fields...)
+	}
+}
+
+// Logger tagged with the request's ID.
+func (s *ApiServer) reqLog(c *gin.Context) *zap.SugaredLogger {
+	return requestid.Logger(c.Request.Context(), s.log)
+}
+
+// Log panics through zap rather than gin's text logger.
+func recovery(log *zap.SugaredLogger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		requestid.Logger(c.Request.Context(), log).Errorw("Panic handling request", "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "" // Unparseable queries could hold anything
+	}
+	redacted := false
+	for _, param := range sensitiveQueryParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return query.Encode()
+}
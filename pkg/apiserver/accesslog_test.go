@@ -0,0 +1,63 @@
+package apiserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLog(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	core, logs := observer.New(zapcore.InfoLevel)
+	s.log = zap.New(core).Sugar()
+
+	// The id makes it all the way to the api client on a proxied request
+	withID := mock.MatchedBy(func(ctx context.Context) bool { return requestid.FromContext(ctx) == "trace-me" })
+	m.On("Fetch", withID, "/rate_limit").Return([]byte(`{}`), nil).Once()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/rate_limit?access_token=secret&client_id=app", nil)
+	req.Header.Set(requestid.Header, "trace-me")
+	s.bootstrapHandler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "trace-me", w.Header().Get(requestid.Header), "id is echoed")
+	m.AssertExpectations(t)
+
+	entries := logs.FilterMessage("Request").All()
+	assert.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "trace-me", fields[requestid.LogField])
+	assert.Equal(t, "/rate_limit", fields["path"])
+	assert.Equal(t, "access_token=REDACTED&client_id=REDACTED", fields["query"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+
+	w = tGet(s, "/view/bottom/1/stars")
+	generated := w.Header().Get(requestid.Header)
+	assert.Len(t, generated, 32, "ids are generated when the caller doesn't send one")
+	fields = logs.FilterMessage("Request").All()[1].ContextMap()
+	assert.Equal(t, generated, fields[requestid.LogField])
+	assert.Equal(t, "/view/bottom/:num/:sortAttribute", fields["route"])
+}
+
+func TestRecoveryLogsThroughZap(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	r := gin.New()
+	r.Use(accessLog(zap.New(core).Sugar()), recovery(zap.New(core).Sugar()))
+	r.GET("/boom", func(*gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	panics := logs.FilterMessage("Panic handling request").All()
+	assert.Len(t, panics, 1)
+	assert.Equal(t, w.Header().Get(requestid.Header), panics[0].ContextMap()[requestid.LogField])
+}
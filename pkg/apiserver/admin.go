@@ -0,0 +1,102 @@
+package apiserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+const ParamPath = "path"
+
+// Only lets through requests with the admin token as a bearer token.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="nfcache admin"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// List the metadata for everything in the github cache.
+func adminListEntries(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.githubCachedAPI.Entries())
+	}
+}
+
+// Metadata for a single cache entry, the path is everything after the route prefix.
+func adminGetEntry(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := s.githubCachedAPI.Entry(entryPath(c))
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// Start caching & auto-updating a path. Fetches the path first if it isn't already cached.
+func adminWatch(s *ApiServer) gin.HandlerFunc {
+	return adminCacheAction(s, s.githubCachedAPI.WatchEndpoint)
+}
+
+// Stop auto-updating a path, the cached data is kept.
+func adminUnwatch(s *ApiServer) gin.HandlerFunc {
+	return adminCacheAction(s, s.githubCachedAPI.UnwatchEndpoint)
+}
+
+// Refetch a cached path immediately.
+func adminRefresh(s *ApiServer) gin.HandlerFunc {
+	return adminCacheAction(s, s.githubCachedAPI.Refresh)
+}
+
+// Remove a path from the cache.
+func adminEvict(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := entryPath(c)
+		if err := s.githubCachedAPI.Evict(path); err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		s.reqLog(c).Infof("Admin evicted %s", path)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Run an action against the path and respond with the entry's metadata afterwards.
+func adminCacheAction(s *ApiServer, action func(string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := entryPath(c)
+		err := action(path)
+		if errors.Is(err, datasource.ErrNotCached) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.reqLog(c).Errorf("Admin action on %s failed with: %v", path, err)
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		entry, ok := s.githubCachedAPI.Entry(path)
+		if !ok { // Upstream returned an empty body so nothing was cached
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		s.reqLog(c).Infof("Admin updated %s", path)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// The cache key path for the entry an admin route acts on, queries are part of the key.
+func entryPath(c *gin.Context) string {
+	return upstreamPath(c.Param(ParamPath), c.Request.URL)
+}
@@ -0,0 +1,114 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tAdminGet(s *ApiServer, url string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.bootstrapHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	s.SetAdminToken("secret")
+	assert.Equal(t, http.StatusUnauthorized, tAdminGet(s, "/admin/entries", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, tAdminGet(s, "/admin/entries", "wrong").Code)
+	assert.Equal(t, http.StatusOK, tAdminGet(s, "/admin/entries", "secret").Code)
+}
+
+func TestAdminEntries(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	s.SetAdminToken("secret")
+
+	w := tAdminGet(s, "/admin/entries", "secret")
+	var entries []datasource.EntryInfo
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, ApiPathNetflixOrgRepos, entries[0].Path)
+	assert.Equal(t, len(repoData()), entries[0].Size)
+	assert.True(t, entries[0].Watched)
+
+	w = tAdminGet(s, "/admin/entries"+ApiPathNetflixOrgRepos, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entry datasource.EntryInfo
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, entries[0], entry)
+
+	w = tAdminGet(s, "/admin/entries/orgs/Unknown", "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminCacheActions(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetAdminToken("secret")
+	handler := s.bootstrapHandler()
+	send := func(method string, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	m.On("FetchAll", mock.Anything, "/orgs/Other").Return([]byte(`{"login":"Other"}`), nil).Once()
+	w := send(http.MethodPost, "/admin/watch/orgs/Other")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"watched":true`)
+
+	w = send(http.MethodPost, "/admin/unwatch/orgs/Other")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"watched":false`)
+
+	m.On("FetchAll", mock.Anything, "/orgs/Other").Return([]byte(nil), errors.New("upstream down")).Once()
+	w = send(http.MethodPost, "/admin/refresh/orgs/Other")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/admin/entries/orgs/Other").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/admin/entries/orgs/Other").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "/admin/refresh/orgs/Other").Code)
+	m.AssertExpectations(t)
+}
+
+func TestAdminQueryEntries(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetAdminToken("secret")
+	handler := s.bootstrapHandler()
+	send := func(method string, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	key := "/search/repositories?q=foo&sort=stars"
+
+	m.On("FetchAll", mock.Anything, key).Return([]byte(`{"items":[]}`), nil).Once()
+	w := send(http.MethodPost, "/admin/watch/search/repositories?sort=stars&q=foo")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entry datasource.EntryInfo
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, key, entry.Path, "the query is part of the key")
+	_, ok := s.githubCachedAPI.Entry("/search/repositories")
+	assert.False(t, ok)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/admin/entries/search/repositories?sort=stars&q=foo").Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/changes/search/repositories?q=foo&sort=stars&since=1h").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/admin/entries/search/repositories").Code)
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/admin/entries/search/repositories?q=foo&sort=stars").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/admin/entries/search/repositories?q=foo&sort=stars").Code)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,62 @@
+package apiserver
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+// Reports the state of upstream circuit breakers & their clients on /healthcheck and the metrics.
+// Must be called before Run().
+func (s *ApiServer) SetBreakers(breakers ...*apiclient.Breaker) {
+	s.breakers = breakers
+	for _, breaker := range breakers {
+		breaker := breaker
+		s.metrics.GaugeFunc(MetricCircuitState, func() float64 {
+			return float64(breaker.State())
+		}, "client", breaker.Name())
+		s.metrics.CounterFunc(MetricCircuitRejected, func() float64 {
+			return float64(breaker.Stats().Rejected)
+		}, "client", breaker.Name())
+		s.metrics.CounterFunc(MetricCircuitOpened, func() float64 {
+			return float64(breaker.Stats().Opened)
+		}, "client", breaker.Name())
+	}
+}
+
+// A failed fetch is a 503 the client can retry when the upstream circuit is open, here or
+// on the peer that owns the key. Github erroring or throttling us is a 502, anything else is a 500.
+func (s *ApiServer) fetchFailed(c *gin.Context, what string, err error) {
+	if circuitOpen(c, err) {
+		s.reqLog(c).Warnf("Fetch %s failed fast: %v", what, err)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	var statusErr *apiclient.StatusError
+	if errors.As(err, &statusErr) {
+		s.reqLog(c).Warnf("Fetch %s failed upstream: %v", what, err)
+		if statusErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(statusErr.RetryAfter.Seconds()))))
+		}
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	s.reqLog(c).Errorf("Fetch %s failed with: %v", what, err)
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// Sets Retry-After when the error is from an open circuit breaker.
+func circuitOpen(c *gin.Context, err error) bool {
+	var openErr *apiclient.CircuitOpenError
+	if errors.As(err, &openErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(openErr.RetryAfter.Seconds())))))
+		return true
+	}
+	var peerErr *datasource.PeerResponseError
+	return errors.As(err, &peerErr) && peerErr.StatusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,52 @@
+package apiserver
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestOpenCircuit(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	m := new(apiclient.ApiClientMock)
+	breaker := apiclient.NewBreaker(m, apiclient.BreakerOptions{WindowSize: 1, MinRequests: 1})
+	cache := datasource.NewCachedAPI(breaker, logger)
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return(repoData(), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(ApiPathNetflixOrgRepos))
+	s := New(cache, logger)
+	s.SetBreakers(breaker)
+	s.SetHealth(HealthOptions{Required: []string{ApiPathNetflixOrgRepos}})
+	assert.Equal(t, apiclient.BreakerClosed, s.healthReport(time.Now()).Upstream[0].Circuit)
+
+	m.On("Fetch", mock.Anything, "/rate_limit").Return([]byte(nil), errors.New("upstream down")).Once()
+	assert.Equal(t, http.StatusInternalServerError, tGet(s, "/rate_limit").Code)
+	assert.Equal(t, apiclient.BreakerOpen, breaker.State())
+
+	// Misses fail fast without calling upstream, cached data is still served
+	w := tGet(s, "/rate_limit")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, tGet(s, "/view/bottom/1/stars").Code)
+	assert.Equal(t, http.StatusOK, tGet(s, ApiPathNetflixOrgRepos).Code)
+	m.AssertNumberOfCalls(t, "Fetch", 1)
+
+	w = tGet(s, "/healthcheck")
+	assert.Equal(t, http.StatusOK, w.Code, "cached data is still served")
+	assert.Contains(t, w.Body.String(), `"status":"degraded"`)
+	assert.Contains(t, w.Body.String(), "github circuit open")
+
+	var metrics bytes.Buffer
+	s.metrics.WriteTo(&metrics)
+	assert.Contains(t, metrics.String(), "# TYPE nfcache_upstream_circuit_state gauge\n")
+	assert.Contains(t, metrics.String(), `nfcache_upstream_circuit_state{client="github"} 1`)
+	assert.Contains(t, metrics.String(), `nfcache_upstream_circuit_rejected_total{client="github"} 1`)
+	assert.Contains(t, metrics.String(), `nfcache_upstream_circuit_opened_total{client="github"} 1`)
+}
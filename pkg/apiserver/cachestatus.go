@@ -0,0 +1,50 @@
+package apiserver
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+const (
+	HeaderCache     = "X-Cache"
+	HeaderUpdatedAt = "X-Nfcache-Updated-At"
+
+	CacheHit   = "HIT"
+	CacheMiss  = "MISS"
+	CacheStale = "STALE"
+)
+
+// Let clients know where the data came from and how long they can hold onto it.
+// Views pass the payload they were built from. With client keys in use responses depend
+// on the caller's key, so shared caches mustn't keep them.
+func (s *ApiServer) setCacheHeaders(c *gin.Context, payload datasource.Payload) {
+	c.Header(HeaderUpdatedAt, payload.LastUpdated.UTC().Format(time.RFC3339))
+	visibility := "public"
+	if len(s.clients) > 0 {
+		visibility = "private"
+		c.Header("Vary", "Authorization, "+HeaderApiKey)
+	}
+	if !payload.Cached {
+		c.Header(HeaderCache, CacheMiss)
+		c.Header("Cache-Control", "no-cache")
+		return
+	}
+
+	age := time.Since(payload.LastUpdated)
+	if age < 0 {
+		age = 0
+	}
+	c.Header("Age", strconv.Itoa(int(age.Seconds())))
+	if payload.Stale {
+		c.Header(HeaderCache, CacheStale)
+		c.Header("Cache-Control", "max-age=0, must-revalidate")
+		return
+	}
+	c.Header(HeaderCache, CacheHit)
+	// Clients can hold onto it until we're due to refresh it
+	c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(payload.MaxAge.Seconds())))
+}
@@ -0,0 +1,65 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSetCacheHeaders(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	updated := time.Now().Add(-90 * time.Second)
+	cases := []struct {
+		payload      datasource.Payload
+		cache        string
+		cacheControl string
+		age          string
+	}{
+		{datasource.Payload{LastUpdated: updated}, CacheMiss, "no-cache", ""},
+		{datasource.Payload{Cached: true, LastUpdated: updated, MaxAge: 30 * time.Second}, CacheHit, "public, max-age=30", "90"},
+		{datasource.Payload{Cached: true, LastUpdated: updated, Stale: true}, CacheStale, "max-age=0, must-revalidate", "90"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		s.setCacheHeaders(c, tc.payload)
+		assert.Equal(t, tc.cache, w.Header().Get(HeaderCache))
+		assert.Equal(t, tc.cacheControl, w.Header().Get("Cache-Control"), tc.cache)
+		assert.Equal(t, tc.age, w.Header().Get("Age"), tc.cache)
+		assert.Equal(t, updated.UTC().Format(time.RFC3339), w.Header().Get(HeaderUpdatedAt))
+		assert.Empty(t, w.Header().Get("Vary"))
+	}
+}
+
+func TestCacheStatusHeaders(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+
+	for _, url := range []string{ApiPathNetflixOrgRepos, "/view/bottom/1/stars"} {
+		w := tGet(s, url)
+		assert.Equal(t, CacheHit, w.Header().Get(HeaderCache), url)
+		assert.Regexp(t, `^public, max-age=(59|60)$`, w.Header().Get("Cache-Control"), url)
+		assert.NotEmpty(t, w.Header().Get(HeaderUpdatedAt), url)
+	}
+
+	m.On("Fetch", mock.Anything, "/users/octocat").Return([]byte(`{"login":"octocat"}`), nil).Once()
+	w := tGet(s, "/users/octocat")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, CacheMiss, w.Header().Get(HeaderCache))
+	assert.Empty(t, w.Header().Get("Age"))
+}
+
+func TestCacheHeadersWithClients(t *testing.T) {
+	s := tClientServer(t)
+	for _, tc := range []struct{ url, key string }{{ApiPathNetflixOrgRepos, "mirror-key"}, {"/view/bottom/1/stars", "dash-key"}} {
+		w := tClientGet(s, tc.url, tc.key)
+		assert.Equal(t, http.StatusOK, w.Code, tc.url)
+		assert.Regexp(t, `^private, max-age=(59|60)$`, w.Header().Get("Cache-Control"), "shared caches can't serve it to other keys")
+		assert.Equal(t, "Authorization, X-Api-Key", w.Header().Get("Vary"), tc.url)
+	}
+}
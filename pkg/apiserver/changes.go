@@ -0,0 +1,122 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/changes"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+const (
+	ParamSince = "since" // How far back to diff from, a duration like 24h or an RFC 3339 time
+	ParamFrom  = "from"  // Version to diff from, overrides since
+	ParamTo    = "to"    // Version to diff to, defaults to the latest
+
+	DefaultChangesSince = 24 * time.Hour
+)
+
+type changesResponse struct {
+	Path     string                 `json:"path"`
+	From     datasource.VersionInfo `json:"from"`
+	To       datasource.VersionInfo `json:"to"`
+	Complete bool                   `json:"complete"` // False when the history doesn't go back as far as asked
+	Changed  bool                   `json:"changed"`
+	changes.Diff
+	History []datasource.VersionInfo `json:"history"` // Every version kept for the path, oldest first
+}
+
+// What changed in a watched endpoint between two versions, e.g. /changes/orgs/Netflix/repos?since=24h
+func viewChanges(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := upstreamPath(c.Param(ParamPath), c.Request.URL, ParamSince, ParamFrom, ParamTo)
+		entryHistory, err := s.githubCachedAPI.LoadHistory(path) // Loaded once, it holds the data for every version
+		if errors.Is(err, datasource.ErrNotCached) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.reqLog(c).Errorf("Loading history for %s failed with: %v", path, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		history := entryHistory.Versions()
+		res := changesResponse{Path: path, History: history, Complete: true}
+		var ok bool
+		if res.To, ok = pickVersion(c, history, ParamTo, history[len(history)-1]); !ok {
+			return
+		}
+		if c.Query(ParamFrom) != "" {
+			if res.From, ok = pickVersion(c, history, ParamFrom, history[0]); !ok {
+				return
+			}
+		} else {
+			cutoff, ok := parseSince(c.DefaultQuery(ParamSince, DefaultChangesSince.String()), time.Now())
+			if !ok {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			res.From, res.Complete = versionAt(history, cutoff)
+		}
+
+		diff, err := diffVersions(entryHistory, res.From.Version, res.To.Version)
+		if err != nil {
+			s.reqLog(c).Errorf("Diffing %s failed with: %v", path, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		res.Diff, res.Changed = diff, !diff.Empty()
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// The version named by the query param, or the fallback if it isn't set. 404s for versions we don't have.
+func pickVersion(c *gin.Context, history []datasource.VersionInfo, param string, fallback datasource.VersionInfo) (datasource.VersionInfo, bool) {
+	version := c.Query(param)
+	if version == "" {
+		return fallback, true
+	}
+	for _, v := range history {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	c.AbortWithStatus(http.StatusNotFound)
+	return datasource.VersionInfo{}, false
+}
+
+// Accepts a duration back from now or an absolute time.
+func parseSince(since string, now time.Time) (time.Time, bool) {
+	if d, err := time.ParseDuration(since); err == nil && d >= 0 {
+		return now.Add(-d), true
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// The version that was current at the cutoff. Falls back to the oldest version we have,
+// returning false, when the history doesn't go back that far.
+func versionAt(history []datasource.VersionInfo, cutoff time.Time) (datasource.VersionInfo, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].Taken.After(cutoff) {
+			return history[i], true
+		}
+	}
+	return history[0], false
+}
+
+func diffVersions(history datasource.EntryHistory, from string, to string) (changes.Diff, error) {
+	fromData, err := history.Data(from)
+	if err != nil {
+		return changes.Diff{}, err
+	}
+	toData, err := history.Data(to)
+	if err != nil {
+		return changes.Diff{}, err
+	}
+	return changes.Compare(fromData, toData)
+}
@@ -0,0 +1,59 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/changes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tChanges(t *testing.T, s *ApiServer, url string) (int, changesResponse) {
+	w := tGet(s, url)
+	var res changesResponse
+	if w.Code == http.StatusOK {
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	}
+	return w.Code, res
+}
+
+func TestViewChanges(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return([]byte(`[{"id": 2044029, "name": "astyanax-renamed"}]`), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.Refresh(ApiPathNetflixOrgRepos))
+
+	code, res := tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, res.History, 2)
+	assert.False(t, res.Complete, "history doesn't go back a day")
+	assert.True(t, res.Changed)
+	assert.Equal(t, res.History[0], res.From)
+	assert.Equal(t, res.History[1], res.To)
+	assert.Equal(t, []changes.RepoRename{{ID: 2044029, From: "astyanax", To: "astyanax-renamed"}}, res.Repos.Renamed)
+	assert.NotEmpty(t, res.Repos.Removed)
+
+	// Nothing has changed since the latest version
+	code, res = tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos+"?since=0s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Complete)
+	assert.False(t, res.Changed)
+	assert.Equal(t, res.From, res.To)
+
+	// Explicit versions, either way round
+	code, res = tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos+"?from="+res.History[1].Version+"&to="+res.History[0].Version)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []changes.RepoRename{{ID: 2044029, From: "astyanax-renamed", To: "astyanax"}}, res.Repos.Renamed)
+
+	since := time.Now().Add(time.Hour).Format(time.RFC3339)
+	code, _ = tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos+"?since="+since)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos+"?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = tChanges(t, s, "/changes"+ApiPathNetflixOrgRepos+"?from=unknown")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = tChanges(t, s, "/changes/orgs/Netflix/members")
+	assert.Equal(t, http.StatusNotFound, code, "only watched endpoints have history")
+}
@@ -0,0 +1,190 @@
+package apiserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// What a client key is allowed to request.
+type Scope string
+
+const (
+	ScopeViews  Scope = "views"  // /view routes
+	ScopeCached Scope = "cached" // Cached github endpoints
+	ScopeProxy  Scope = "proxy"  // Anything, including proxied requests that use our github quota
+
+	HeaderApiKey = "X-Api-Key"
+)
+
+// Results recorded against MetricClientRequests.
+const (
+	ClientAllowed      = "allowed"
+	ClientUnauthorized = "unauthorized"
+	ClientForbidden    = "forbidden"
+	ClientLimited      = "limited"
+)
+
+// An nfcache api key and what it can do.
+type Client struct {
+	Name   string  `yaml:"name"`
+	Key    string  `yaml:"key"`
+	Scopes []Scope `yaml:"scopes"`
+	Rate   float64 `yaml:"rate"`  // Requests per second, 0 for no limit
+	Burst  int     `yaml:"burst"` // Requests allowed at once, defaults to the rate rounded up
+}
+
+// Read client keys from a yaml file with a top level `clients` list.
+func LoadClients(path string) ([]Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Clients []Client `yaml:"clients"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse clients file %s: %w", path, err)
+	}
+	return config.Clients, nil
+}
+
+// A configured client with its rate limit state.
+type clientState struct {
+	Client
+	bucket *tokenBucket // nil when the client isn't rate limited
+}
+
+// proxy covers everything, other scopes only cover themselves.
+func (c *clientState) allows(scope Scope) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeProxy {
+			return true
+		}
+	}
+	return false
+}
+
+// Require an api key on public routes. Must be called before Run().
+// Without any clients every request is let through, as before.
+func (s *ApiServer) SetClients(clients []Client) error {
+	states := make([]*clientState, 0, len(clients))
+	seen := map[string]bool{}
+	for _, client := range clients {
+		if client.Name == "" || client.Key == "" {
+			return fmt.Errorf("clients need a name and key")
+		}
+		if seen[client.Key] {
+			return fmt.Errorf("client %s reuses another client's key", client.Name)
+		}
+		seen[client.Key] = true
+		for _, scope := range client.Scopes {
+			if scope != ScopeViews && scope != ScopeCached && scope != ScopeProxy {
+				return fmt.Errorf("client %s has unknown scope %q", client.Name, scope)
+			}
+		}
+		if client.Rate < 0 || client.Burst < 0 {
+			return fmt.Errorf("client %s has a negative rate limit", client.Name)
+		}
+		state := &clientState{Client: client}
+		if client.Rate > 0 {
+			state.bucket = newTokenBucket(client.Rate, client.Burst)
+		}
+		states = append(states, state)
+	}
+	s.clients = states
+	return nil
+}
+
+// Key from the X-Api-Key header or a bearer token.
+func clientKey(c *gin.Context) string {
+	if key := c.GetHeader(HeaderApiKey); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return key
+}
+
+// Compares against every key so lookups take the same time whether or not the key exists.
+func (s *ApiServer) lookupClient(key string) *clientState {
+	var found *clientState
+	for _, client := range s.clients {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(client.Key)) == 1 {
+			found = client
+		}
+	}
+	return found
+}
+
+// Only lets through clients with a key for the scope and quota left in their bucket.
+func (s *ApiServer) clientAuth(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(s.clients) == 0 {
+			c.Next()
+			return
+		}
+		client := s.lookupClient(clientKey(c))
+		if client == nil {
+			s.metrics.Inc(MetricClientRequests, "client", "", "result", ClientUnauthorized)
+			c.Header("WWW-Authenticate", `Bearer realm="nfcache"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(ctxClientName, client.Name)
+		if !client.allows(scope) {
+			s.metrics.Inc(MetricClientRequests, "client", client.Name, "result", ClientForbidden)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if client.bucket != nil {
+			if wait := client.bucket.take(time.Now()); wait > 0 {
+				s.metrics.Inc(MetricClientRequests, "client", client.Name, "result", ClientLimited)
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				c.AbortWithStatus(http.StatusTooManyRequests)
+				return
+			}
+		}
+		s.metrics.Inc(MetricClientRequests, "client", client.Name, "result", ClientAllowed)
+		c.Next()
+	}
+}
+
+// Refills at rate tokens per second up to burst. Safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   *sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst == 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), lock: &sync.Mutex{}}
+}
+
+// Takes a token if there's one available. Otherwise returns how long until there will be.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
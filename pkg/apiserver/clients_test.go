@@ -0,0 +1,98 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tClientGet(s *ApiServer, url string, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if key != "" {
+		req.Header.Set(HeaderApiKey, key)
+	}
+	s.bootstrapHandler().ServeHTTP(w, req)
+	return w
+}
+
+func tClientServer(t *testing.T) *ApiServer {
+	s, m := tServer(t, []string{"Netflix"})
+	clients, err := LoadClients("testdata/clients.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetClients(clients))
+	m.On("Fetch", mock.Anything, "/rate_limit").Return([]byte(`{}`), nil)
+	return s
+}
+
+func TestClientScopes(t *testing.T) {
+	s := tClientServer(t)
+	view, cached, proxied := "/view/bottom/1/stars", ApiPathNetflixOrgRepos, "/rate_limit"
+
+	assert.Equal(t, http.StatusUnauthorized, tClientGet(s, view, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, tClientGet(s, proxied, "wrong").Code)
+
+	assert.Equal(t, http.StatusOK, tClientGet(s, view, "dash-key").Code)
+	assert.Equal(t, http.StatusForbidden, tClientGet(s, cached, "dash-key").Code)
+	assert.Equal(t, http.StatusOK, tClientGet(s, cached, "mirror-key").Code)
+	assert.Equal(t, http.StatusForbidden, tClientGet(s, proxied, "mirror-key").Code)
+	assert.Equal(t, http.StatusForbidden, tClientGet(s, view, "mirror-key").Code)
+
+	for _, path := range []string{view, cached, proxied} {
+		assert.Equal(t, http.StatusOK, tClientGet(s, path, "internal-key").Code, "proxy scope covers %s", path)
+	}
+
+	// Bearer tokens work too
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, cached, nil)
+	req.Header.Set("Authorization", "Bearer mirror-key")
+	s.bootstrapHandler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	s.SetHealth(HealthOptions{Required: []string{ApiPathNetflixOrgRepos}})
+	assert.Equal(t, http.StatusOK, tClientGet(s, "/healthcheck", "").Code, "healthcheck stays open")
+}
+
+func TestClientRateLimit(t *testing.T) {
+	s := tClientServer(t)
+	s.SetAdminToken("secret")
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, tClientGet(s, "/view/bottom/1/stars", "dash-key").Code, "within burst")
+	}
+	w := tClientGet(s, "/view/bottom/1/stars", "dash-key")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "100", w.Header().Get("Retry-After"), "one token every 100s")
+
+	assert.Equal(t, uint64(2), s.metrics.Value(MetricClientRequests, "client", "dashboard", "result", ClientAllowed))
+	assert.Equal(t, uint64(1), s.metrics.Value(MetricClientRequests, "client", "dashboard", "result", ClientLimited))
+
+	w = tAdminGet(s, "/admin/metrics", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `nfcache_client_requests_total{client="dashboard",result="limited"} 1`))
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 0)
+	assert.Equal(t, time.Duration(0), b.take(now))
+	assert.Equal(t, time.Duration(0), b.take(now), "burst defaults to the rate")
+	assert.Equal(t, 500*time.Millisecond, b.take(now))
+	assert.Equal(t, time.Duration(0), b.take(now.Add(500*time.Millisecond)), "refilled")
+	assert.Equal(t, time.Duration(0), b.take(now.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), b.take(now.Add(time.Hour)), "refill is capped at the burst")
+	assert.Equal(t, 500*time.Millisecond, b.take(now.Add(time.Hour)))
+}
+
+func TestSetClientsValidation(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	assert.NotNil(t, s.SetClients([]Client{{Name: "a"}}), "key is required")
+	assert.NotNil(t, s.SetClients([]Client{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}), "keys are unique")
+	assert.NotNil(t, s.SetClients([]Client{{Name: "a", Key: "k", Scopes: []Scope{"admin"}}}))
+	assert.Nil(t, s.SetClients(nil))
+	assert.Equal(t, http.StatusOK, tClientGet(s, "/view/bottom/1/stars", "").Code, "no clients means open access")
+}
@@ -0,0 +1,47 @@
+package apiserver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+// Pick the precompressed encoding the client prefers from an Accept-Encoding header.
+// Ties on q-value go to the server's order of preference. Returns "" for identity.
+func preferredEncoding(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if coding == "*" {
+			wildcard = weight
+		} else {
+			weights[coding] = weight
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range datasource.ContentEncodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight = wildcard
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
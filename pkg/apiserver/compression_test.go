@@ -0,0 +1,58 @@
+package apiserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferredEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"identity":               "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "br",
+		"br;q=0.5, gzip":         "gzip",
+		"GZIP;q=0.8":             "gzip",
+		"*":                      "br",
+		"*;q=0.5, br;q=0":        "gzip",
+		"gzip;q=0, deflate":      "",
+		"gzip;q=nonsense, br":    "br",
+		"deflate, gzip;q=1.0, *": "br",
+	}
+	for header, expected := range cases {
+		assert.Equal(t, expected, preferredEncoding(header), "Accept-Encoding: %s", header)
+	}
+}
+
+func TestCompressedResponses(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	send := func(url string, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		s.bootstrapHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(ApiPathNetflixOrgRepos, "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Header().Get("Vary"), "Accept-Encoding")
+	gr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	assert.Nil(t, err)
+	body, err := io.ReadAll(gr)
+	assert.Nil(t, err)
+	assert.Equal(t, repoData(), body)
+
+	w = send(ApiPathNetflixOrgRepos, "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, repoData(), w.Body.Bytes())
+
+	w = send(ApiPathNetflixOrgRepos+"?format=csv", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "re-encoded formats aren't compressed")
+}
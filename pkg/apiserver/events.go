@@ -0,0 +1,219 @@
+package apiserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/changes"
+	"github.com/njo/nfcache/pkg/datasource"
+	"go.uber.org/zap"
+)
+
+const (
+	ParamPrefix = "prefix" // Only send events for paths starting with this, can be repeated
+	ParamDiff   = "diff"   // Include what changed in each event
+
+	EventUpdate = "update"
+
+	MetricEventSubscribers = "nfcache_event_subscribers"
+
+	eventBuffer      = 16               // Events queued per subscriber before it's dropped as too slow
+	eventHeartbeat   = 30 * time.Second // Keeps idle connections from being closed by proxies
+	eventRetryMillis = 5000             // How long clients wait before reconnecting
+)
+
+// Sent when a cache entry's data changes.
+type updateEvent struct {
+	Path            string        `json:"path"`
+	Version         string        `json:"version"`
+	PreviousVersion string        `json:"previous_version,omitempty"` // Empty for new entries
+	UpdatedAt       time.Time     `json:"updated_at"`
+	Diff            *changes.Diff `json:"diff,omitempty"`
+}
+
+type eventSubscriber struct {
+	prefixes []string        // Empty for every path
+	readable map[string]bool // Paths the client's scope lets it read, nil for every path
+	diff     bool
+	events   chan sse.Event
+}
+
+func (e *eventSubscriber) wants(path string) bool {
+	if e.readable != nil && !e.readable[path] {
+		return false
+	}
+	if len(e.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fans cache updates out to SSE subscribers. Subscribers that fall behind are dropped
+// rather than holding up the refresh that published the event. Safe for concurrent use.
+type eventHub struct {
+	log *zap.SugaredLogger
+
+	lock   *sync.Mutex
+	subs   map[*eventSubscriber]bool
+	nextID uint64
+	closed bool
+}
+
+func newEventHub(log *zap.SugaredLogger) *eventHub {
+	return &eventHub{log: log, lock: &sync.Mutex{}, subs: map[*eventSubscriber]bool{}}
+}
+
+// Returns false once the hub is closed.
+func (h *eventHub) subscribe(sub *eventSubscriber) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return false
+	}
+	h.subs[sub] = true
+	return true
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+func (h *eventHub) subscribers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subs)
+}
+
+// Ends every stream so the http server can shut down.
+func (h *eventHub) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Cache update hook, only changed data is sent. The diff is worked out once, only if someone wants it,
+// and outside the lock so slow diffs don't hold up subscribing or other refreshes publishing.
+func (h *eventHub) publish(update datasource.Update) {
+	if !update.Changed {
+		return
+	}
+	event := updateEvent{Path: update.Path, Version: update.Version, PreviousVersion: update.PreviousVersion, UpdatedAt: update.LastUpdated}
+	withDiff := event
+	if update.Previous != nil && h.wantsDiff(update.Path) {
+		if diff, err := changes.Compare(update.Previous, update.Data); err == nil {
+			withDiff.Diff = &diff
+		} else {
+			h.log.Debugf("Unable to diff %s for events: %v", update.Path, err)
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.nextID++
+	for sub := range h.subs {
+		if !sub.wants(update.Path) {
+			continue
+		}
+		data := event
+		if sub.diff {
+			data = withDiff
+		}
+		select {
+		case sub.events <- sse.Event{Id: strconv.FormatUint(h.nextID, 10), Event: EventUpdate, Data: data}:
+		default:
+			h.log.Warnf("Dropping a slow event subscriber")
+			delete(h.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (h *eventHub) wantsDiff(path string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for sub := range h.subs {
+		if sub.diff && sub.wants(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Streams cache updates as server-sent events, e.g. /events?prefix=/orgs/Netflix&diff=true
+func streamEvents(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sub := &eventSubscriber{
+			prefixes: c.QueryArray(ParamPrefix),
+			readable: s.readablePaths(c),
+			diff:     c.Query(ParamDiff) == "true",
+			events:   make(chan sse.Event, eventBuffer),
+		}
+		if !s.events.subscribe(sub) {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		defer s.events.unsubscribe(sub)
+
+		c.Header("Content-Type", sse.ContentType)
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // Stop nginx holding events back
+		c.Status(http.StatusOK)
+		fmt.Fprintf(c.Writer, "retry: %d\n\n", eventRetryMillis) // No data so clients don't see it as an event
+		c.Writer.Flush()                                         // Let the client know it's subscribed
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-sub.events:
+				if !ok {
+					return false
+				}
+				c.Render(-1, event)
+				return true
+			case <-heartbeat.C:
+				_, err := io.WriteString(w, ":\n\n") // Comment lines are ignored by clients
+				return err == nil
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// Clients without the proxy scope only get events for the cached endpoints they could fetch,
+// not entries watched through the admin api or peers. nil when every path is readable.
+func (s *ApiServer) readablePaths(c *gin.Context) map[string]bool {
+	if len(s.clients) == 0 {
+		return nil
+	}
+	if client := s.lookupClient(clientKey(c)); client != nil && client.allows(ScopeProxy) {
+		return nil
+	}
+	readable := map[string]bool{}
+	for _, path := range s.CachedEndpoints() {
+		readable[path] = true
+	}
+	return readable
+}
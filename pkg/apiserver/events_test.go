@@ -0,0 +1,104 @@
+package apiserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Reads the next event's fields, skipping the retry & heartbeat lines. Returns nil once the stream ends.
+func tNextEvent(scanner *bufio.Scanner) map[string]string {
+	event := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if _, ok := event["data"]; ok {
+				return event
+			}
+			continue
+		}
+		if field, value, ok := strings.Cut(line, ":"); ok && field != "" {
+			event[field] = strings.TrimSpace(value)
+		}
+	}
+	return nil
+}
+
+func TestStreamEvents(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	srv := httptest.NewServer(s.bootstrapHandler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/events?prefix=/orgs/Netflix/repos&diff=true")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(res.Body)
+	assert.True(t, scanner.Scan())
+	assert.Equal(t, "retry: 5000", scanner.Text(), "subscribed once the retry is sent")
+	assert.Equal(t, 1, s.events.subscribers())
+
+	// Filtered out by the prefix
+	m.On("FetchAll", mock.Anything, "/orgs/Netflix/members").Return([]byte(`[]`), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.WatchEndpoint("/orgs/Netflix/members"))
+	// Unchanged data isn't sent
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return(repoData(), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.Refresh(ApiPathNetflixOrgRepos))
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return([]byte(`[{"id": 2044029, "name": "astyanax-renamed"}]`), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.Refresh(ApiPathNetflixOrgRepos))
+
+	event := tNextEvent(scanner)
+	assert.Equal(t, "2", event["id"], "ids count every changed update, including filtered ones")
+	assert.Equal(t, EventUpdate, event["event"])
+	var update updateEvent
+	assert.Nil(t, json.Unmarshal([]byte(event["data"]), &update))
+	assert.Equal(t, ApiPathNetflixOrgRepos, update.Path)
+	assert.NotEmpty(t, update.Version)
+	assert.NotEmpty(t, update.PreviousVersion)
+	assert.NotEqual(t, update.Version, update.PreviousVersion)
+	if assert.NotNil(t, update.Diff) && assert.NotNil(t, update.Diff.Repos) {
+		assert.Equal(t, "astyanax-renamed", update.Diff.Repos.Renamed[0].To)
+	}
+
+	s.events.close()
+	assert.Nil(t, tNextEvent(scanner), "closing the hub ends the stream")
+	assert.Equal(t, http.StatusServiceUnavailable, tGet(s, "/events").Code)
+}
+
+func TestStreamEventsScope(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	clients, err := LoadClients("testdata/clients.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetClients(clients))
+	srv := httptest.NewServer(s.bootstrapHandler())
+	defer srv.Close()
+	subscribe := func(key string) *bufio.Scanner {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?diff=true", nil)
+		req.Header.Set(HeaderApiKey, key)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		t.Cleanup(func() { res.Body.Close() })
+		scanner := bufio.NewScanner(res.Body)
+		assert.True(t, scanner.Scan(), "subscribed once the retry is sent")
+		return scanner
+	}
+	mirror, internal := subscribe("mirror-key"), subscribe("internal-key")
+
+	// Watched through the admin api, only readable by proxied requests
+	m.On("FetchAll", mock.Anything, "/orgs/secret").Return([]byte(`[]`), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.WatchEndpoint("/orgs/secret"))
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return([]byte(`[{"id": 2044029, "name": "astyanax-renamed"}]`), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.Refresh(ApiPathNetflixOrgRepos))
+
+	assert.Contains(t, tNextEvent(mirror)["data"], ApiPathNetflixOrgRepos, "cached scope skips paths it can't fetch")
+	assert.Contains(t, tNextEvent(internal)["data"], "/orgs/secret", "proxy scope can read every path")
+	s.events.close()
+}
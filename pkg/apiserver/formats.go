@@ -0,0 +1,262 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+type OutputFormat string
+
+const (
+	FormatJSON   OutputFormat = "json"
+	FormatCSV    OutputFormat = "csv"
+	FormatNDJSON OutputFormat = "ndjson"
+	FormatYAML   OutputFormat = "yaml"
+)
+
+const (
+	MIMECSV    = "text/csv"
+	MIMENDJSON = "application/x-ndjson"
+	MIMEYAML   = "application/yaml"
+)
+
+// Views can be returned as pairs [["repo",123],...] or objects [{"name":"repo","value":123},...]
+type ViewShape string
+
+const (
+	ShapePair   ViewShape = "pair"
+	ShapeObject ViewShape = "object"
+)
+
+const ParamFormat = "format"
+const ParamShape = "shape"
+
+// Offered in order of preference, the first entry is used when the client accepts anything.
+var offeredMIMEs = []string{gin.MIMEJSON, MIMECSV, MIMENDJSON, MIMEYAML, gin.MIMEYAML}
+
+var mimeFormats = map[string]OutputFormat{
+	gin.MIMEJSON: FormatJSON,
+	MIMECSV:      FormatCSV,
+	MIMENDJSON:   FormatNDJSON,
+	MIMEYAML:     FormatYAML,
+	gin.MIMEYAML: FormatYAML,
+}
+
+func (f OutputFormat) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return MIMECSV + "; charset=utf-8"
+	case FormatNDJSON:
+		return MIMENDJSON
+	case FormatYAML:
+		return MIMEYAML + "; charset=utf-8"
+	}
+	return gin.MIMEJSON
+}
+
+// Pick the output format from the format query param, falling back to the Accept header.
+// Aborts the request and returns false if no supported format was requested.
+func negotiateFormat(c *gin.Context) (OutputFormat, bool) {
+	if param, ok := c.GetQuery(ParamFormat); ok {
+		format := OutputFormat(param)
+		switch format {
+		case FormatJSON, FormatCSV, FormatNDJSON, FormatYAML:
+			return format, true
+		}
+		c.AbortWithStatus(http.StatusBadRequest)
+		return "", false
+	}
+
+	mime := c.NegotiateFormat(offeredMIMEs...)
+	if mime == "" {
+		c.AbortWithStatus(http.StatusNotAcceptable)
+		return "", false
+	}
+	return mimeFormats[mime], true
+}
+
+// Read the view shape from the query params. Aborts the request and returns false if it's invalid.
+func viewShape(c *gin.Context) (ViewShape, bool) {
+	shape := ViewShape(c.DefaultQuery(ParamShape, string(ShapePair)))
+	if shape != ShapePair && shape != ShapeObject {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return "", false
+	}
+	return shape, true
+}
+
+// Re-encode a raw json payload from the upstream api into the given format.
+// JSON payloads are passed through untouched.
+func encodePayload(jsonData []byte, format OutputFormat) ([]byte, error) {
+	if format == FormatJSON {
+		return jsonData, nil
+	}
+
+	// UseNumber keeps large ids from being mangled into floats
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCSV:
+		header, rows := tabulate(value)
+		return encodeCSV(header, rows)
+	case FormatNDJSON:
+		if list, ok := value.([]any); ok {
+			return encodeNDJSON(list)
+		}
+		return encodeNDJSON([]any{value})
+	case FormatYAML:
+		return yaml.Marshal(yamlNumbers(value))
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// Encode a view result into the given format and shape.
+// The sort attribute is used as the value column name for CSV output.
+func encodeRepoPairs(pairs []repoPair, format OutputFormat, shape ViewShape, attribute string) ([]byte, error) {
+	if format == FormatCSV {
+		rows := make([][]string, len(pairs))
+		for i, p := range pairs {
+			rows[i] = []string{p.Name, fmt.Sprint(p.Value)}
+		}
+		return encodeCSV([]string{"name", attribute}, rows)
+	}
+
+	values := make([]any, len(pairs))
+	for i := range pairs {
+		if shape == ShapeObject {
+			values[i] = repoObject{pairs[i].Name, pairs[i].Value}
+		} else {
+			values[i] = []any{pairs[i].Name, pairs[i].Value}
+		}
+	}
+
+	switch format {
+	case FormatJSON:
+		return json.Marshal(values)
+	case FormatNDJSON:
+		return encodeNDJSON(values)
+	case FormatYAML:
+		return yaml.Marshal(values)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// Flatten a decoded json value into a header and rows.
+// Lists of objects get a column per key, nested values are left as json.
+func tabulate(value any) ([]string, [][]string) {
+	list, ok := value.([]any)
+	if !ok {
+		list = []any{value}
+	}
+
+	keys := make(map[string]struct{})
+	for _, item := range list {
+		if obj, ok := item.(map[string]any); ok {
+			for k := range obj {
+				keys[k] = struct{}{}
+			}
+		}
+	}
+
+	if len(keys) == 0 { // List of scalars
+		rows := make([][]string, len(list))
+		for i, item := range list {
+			rows[i] = []string{csvCell(item)}
+		}
+		return []string{"value"}, rows
+	}
+
+	header := make([]string, 0, len(keys))
+	for k := range keys {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+
+	rows := make([][]string, len(list))
+	for i, item := range list {
+		obj, _ := item.(map[string]any)
+		row := make([]string, len(header))
+		for j, k := range header {
+			row[j] = csvCell(obj[k])
+		}
+		rows[i] = row
+	}
+	return header, rows
+}
+
+func csvCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	}
+	nested, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(nested)
+}
+
+func encodeCSV(header []string, rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	if err := w.WriteAll(rows); err != nil { // WriteAll flushes
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeNDJSON(values []any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf) // Encode appends the newline for us
+	encoder.SetEscapeHTML(false)
+	for _, v := range values {
+		if err := encoder.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// The yaml encoder would quote json.Number as a string, swap them for real numbers.
+func yamlNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case []any:
+		for i := range v {
+			v[i] = yamlNumbers(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = yamlNumbers(v[k])
+		}
+	}
+	return value
+}
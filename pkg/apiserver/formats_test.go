@@ -0,0 +1,91 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		url    string
+		accept string
+		format OutputFormat
+		status int
+	}{
+		{"/", "", FormatJSON, http.StatusOK},
+		{"/", "*/*", FormatJSON, http.StatusOK},
+		{"/", "text/csv;q=0.9", FormatCSV, http.StatusOK},
+		{"/", "application/x-ndjson", FormatNDJSON, http.StatusOK},
+		{"/", "application/x-yaml", FormatYAML, http.StatusOK},
+		{"/?format=yaml", "application/json", FormatYAML, http.StatusOK},
+		{"/?format=xml", "", "", http.StatusBadRequest},
+		{"/", "text/html", "", http.StatusNotAcceptable},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, tc.url, nil)
+		c.Request.Header.Set("Accept", tc.accept)
+		format, ok := negotiateFormat(c)
+		assert.Equal(t, tc.format, format, "%s with Accept %q", tc.url, tc.accept)
+		assert.Equal(t, tc.status == http.StatusOK, ok)
+		assert.Equal(t, tc.status, w.Code)
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	payload := []byte(`[{"name":"a","id":600000001,"owner":{"login":"x"}},{"name":"b<c>","fork":true}]`)
+
+	r, e := encodePayload(payload, FormatJSON)
+	assert.Equal(t, payload, r, "json is passed through")
+	assert.Nil(t, e)
+
+	r, e = encodePayload(payload, FormatCSV)
+	assert.Equal(t, "fork,id,name,owner\n,600000001,a,\"{\"\"login\"\":\"\"x\"\"}\"\ntrue,,b<c>,\n", string(r))
+	assert.Nil(t, e)
+
+	r, e = encodePayload(payload, FormatNDJSON)
+	assert.Equal(t, "{\"id\":600000001,\"name\":\"a\",\"owner\":{\"login\":\"x\"}}\n{\"fork\":true,\"name\":\"b<c>\"}\n", string(r))
+	assert.Nil(t, e)
+
+	r, e = encodePayload(payload, FormatYAML)
+	assert.Contains(t, string(r), "id: 600000001\n", "numbers aren't quoted or converted to floats")
+	assert.Nil(t, e)
+
+	r, e = encodePayload([]byte(`{"login":"Netflix","id":1}`), FormatCSV)
+	assert.Equal(t, "id,login\n1,Netflix\n", string(r), "single objects become a single row")
+	assert.Nil(t, e)
+
+	_, e = encodePayload([]byte(`not json`), FormatCSV)
+	assert.NotNil(t, e)
+}
+
+func TestEncodeRepoPairs(t *testing.T) {
+	pairs, e := BottomNRepoPairs(repoData(), IssuesField, 2)
+	assert.Nil(t, e)
+
+	r, e := encodeRepoPairs(pairs, FormatJSON, ShapePair, "open_issues")
+	assert.Equal(t, `[["Netflix/aws-autoscaling",1],["Netflix/servo",0]]`, string(r))
+	assert.Nil(t, e)
+
+	r, e = encodeRepoPairs(pairs, FormatJSON, ShapeObject, "open_issues")
+	assert.Equal(t, `[{"name":"Netflix/aws-autoscaling","value":1},{"name":"Netflix/servo","value":0}]`, string(r))
+	assert.Nil(t, e)
+
+	r, e = encodeRepoPairs(pairs, FormatCSV, ShapePair, "open_issues")
+	assert.Equal(t, "name,open_issues\nNetflix/aws-autoscaling,1\nNetflix/servo,0\n", string(r))
+	assert.Nil(t, e)
+
+	r, e = encodeRepoPairs(pairs, FormatNDJSON, ShapeObject, "open_issues")
+	assert.Equal(t, "{\"name\":\"Netflix/aws-autoscaling\",\"value\":1}\n{\"name\":\"Netflix/servo\",\"value\":0}\n", string(r))
+	assert.Nil(t, e)
+
+	r, e = encodeRepoPairs(pairs, FormatYAML, ShapeObject, "open_issues")
+	assert.Equal(t, "- name: Netflix/aws-autoscaling\n  value: 1\n- name: Netflix/servo\n  value: 0\n", string(r))
+	assert.Nil(t, e)
+}
@@ -2,61 +2,122 @@ package apiserver
 
 import (
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
 )
 
-// Simple health check
-func healthcheck(c *gin.Context) {
-	c.String(http.StatusOK, "Ok")
-}
-
 // Fetch the path from the github cached api.
-// Note: currently no difference between this and the request proxy
+// Responses can be re-encoded to other formats, see negotiateFormat.
 func githubCachedFetch(s *ApiServer, path string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		jsonData, err := s.githubCachedAPI.Fetch(c.Request.Context(), path)
+		format, ok := negotiateFormat(c)
+		if !ok {
+			return
+		}
+		path := upstreamPath(path, c.Request.URL, ParamFormat)
+		acceptEncoding := ""
+		if format == FormatJSON { // Other formats are built per request so there's no precompressed copy
+			acceptEncoding = c.GetHeader("Accept-Encoding")
+		}
+		payload, err := s.fetchPayload(c, path, acceptEncoding)
 		if err != nil {
-			s.log.Errorf("Fetch %s failed with: %v", path, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			s.fetchFailed(c, path, err)
 			return
 		}
-		if len(jsonData) == 0 {
+		if len(payload.Data) == 0 {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
-		c.Data(http.StatusOK, gin.MIMEJSON, jsonData)
+		c.Header("Vary", "Accept, Accept-Encoding")
+		s.setCacheHeaders(c, payload)
+		formatTag := string(format)
+		if format == FormatJSON { // Same representation as the proxy serves so keep the same tag
+			formatTag = ""
+		}
+		if payload.Cached && notModified(c, entityTag(payload.Version, formatTag, payload.Encoding), payload.LastUpdated) {
+			return
+		}
+		body, err := encodePayload(payload.Data, format) // JSON (compressed or not) is passed through as is
+		if err != nil {
+			s.reqLog(c).Errorf("Encoding %s as %s failed with: %v", path, format, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		writePayload(c, format.ContentType(), payload.Encoding, body)
 	}
 }
 
 // Fetch the path from the github cached api. This is expected to be a cache miss.
+// Paths watched at runtime are served from the cache through here too.
 func githubProxyRequest(s *ApiServer) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		path := c.Request.URL.Path
-		jsonData, err := s.githubCachedAPI.Fetch(c.Request.Context(), path)
+		path := upstreamPath(c.Request.URL.Path, c.Request.URL)
+		payload, err := s.fetchPayload(c, path, c.GetHeader("Accept-Encoding"))
 		if err != nil {
-			s.log.Errorf("Fetch %s failed with: %v", path, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			s.fetchFailed(c, path, err)
 			return
 		}
-		if len(jsonData) == 0 {
+		if len(payload.Data) == 0 {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
-		c.Data(http.StatusOK, gin.MIMEJSON, jsonData)
+		c.Header("Vary", "Accept-Encoding")
+		s.setCacheHeaders(c, payload)
+		if payload.Cached && notModified(c, entityTag(payload.Version, payload.Encoding), payload.LastUpdated) {
+			return
+		}
+		writePayload(c, gin.MIMEJSON, payload.Encoding, payload.Data)
+	}
+}
+
+// Fetch the path, using a precompressed copy if the client accepts one and it's cached.
+func (s *ApiServer) fetchPayload(c *gin.Context, path string, acceptEncoding string) (datasource.Payload, error) {
+	return s.githubCachedAPI.FetchPayload(c.Request.Context(), path, preferredEncoding(acceptEncoding))
+}
+
+func writePayload(c *gin.Context, contentType string, encoding string, body []byte) {
+	if encoding != "" {
+		c.Header("Content-Encoding", encoding)
 	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// Append the request's query to the path, minus any params nfcache handles itself.
+func upstreamPath(path string, requestURL *url.URL, ownParams ...string) string {
+	query := requestURL.Query()
+	for _, param := range ownParams {
+		query.Del(param)
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
 }
 
 const ParamSortAttribute = "sortAttribute"
 const ParamNum = "num"
+const ParamOrg = "org"
 
 // Custom view over the cached github repo data.
+// Routes without an org param use the server's default org.
 // Note: the underlying data is cached but the view is built each time.
 func viewBottomRepos(s *ApiServer) gin.HandlerFunc {
 	var attributes = map[string]GithubSortField{ // Map valid urls to their sort field
 		"forks": ForksField, "open_issues": IssuesField, "stars": StarsField, "last_updated": UpdatedField}
 	return func(c *gin.Context) {
+		org := s.orgs[0]
+		if param := c.Param(ParamOrg); param != "" {
+			var ok bool
+			if org, ok = s.allowedOrg(param); !ok {
+				// Only orgs in the allowlist are cached so there's nothing to build the view from.
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+		}
+
 		numResults, err := strconv.Atoi(c.Param(ParamNum))
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
@@ -70,20 +131,47 @@ func viewBottomRepos(s *ApiServer) gin.HandlerFunc {
 			return
 		}
 
-		jsonRepos, err := s.githubCachedAPI.Fetch(c.Request.Context(), ApiPathNetflixOrgRepos)
-		if err != nil || len(jsonRepos) == 0 {
-			s.log.Errorf("Fetch bottomRepo data failed with: %v", err)
+		format, ok := negotiateFormat(c)
+		if !ok {
+			return
+		}
+		shape, ok := viewShape(c)
+		if !ok {
+			return
+		}
+
+		repos, err := s.githubCachedAPI.FetchPayload(c.Request.Context(), OrgReposPath(org), "")
+		if err != nil {
+			s.fetchFailed(c, "bottomRepo data for "+org, err)
+			return
+		}
+		if len(repos.Data) == 0 {
+			s.reqLog(c).Errorf("Fetch bottomRepo data for %s returned nothing", org)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// The view is built from the repo data so only changes when that does
+		c.Header("Vary", "Accept")
+		s.setCacheHeaders(c, repos)
+		etag := entityTag(repos.Version, viewTag(c.Request.URL.Path, string(format), string(shape)))
+		if repos.Cached && notModified(c, etag, repos.LastUpdated) {
+			return
+		}
+		jsonRepos := repos.Data
+		sortedRepos, err := BottomNRepoPairs(jsonRepos, attributes[sortAttribute], numResults)
+		if err != nil {
+			s.reqLog(c).Errorf("BottomNRepos sort failed with: %v", err)
+			s.reqLog(c).Debug("full repoData:\n%v", jsonRepos)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		sortedJsonRepos, err := BottomNRepos(jsonRepos, attributes[sortAttribute], numResults)
+		body, err := encodeRepoPairs(sortedRepos, format, shape, sortAttribute)
 		if err != nil {
-			s.log.Errorf("BottomNRepos sort failed with: %v", err)
-			s.log.Debug("full repoData:\n%v", jsonRepos)
+			s.reqLog(c).Errorf("Encoding bottomRepo view as %s failed with: %v", format, err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		c.Data(http.StatusOK, gin.MIMEJSON, sortedJsonRepos)
+		c.Data(http.StatusOK, format.ContentType(), body)
 	}
 }
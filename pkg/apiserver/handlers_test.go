@@ -0,0 +1,119 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/fakegithub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+)
+
+// Server for the given orgs with each org's repos already cached.
+func tServer(t *testing.T, orgs []string) (*ApiServer, *apiclient.ApiClientMock) {
+	logger := zaptest.NewLogger(t).Sugar()
+	m := new(apiclient.ApiClientMock)
+	cache := datasource.NewCachedAPI(m, logger)
+	for _, org := range orgs {
+		m.On("FetchAll", mock.Anything, OrgReposPath(org)).Return(repoData(), nil).Once()
+		assert.Nil(t, cache.WatchEndpoint(OrgReposPath(org)))
+	}
+	return NewWithOrgs(cache, logger, orgs), m
+}
+
+func tGet(s *ApiServer, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.bootstrapHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestCachedOrgEndpoints(t *testing.T) {
+	assert.Equal(t, []string{"/", "/orgs/Netflix", "/orgs/Netflix/members", "/orgs/Netflix/repos"}, CachedEndpoints())
+	assert.Equal(t,
+		[]string{"/", "/orgs/a", "/orgs/a/members", "/orgs/a/repos", "/orgs/b", "/orgs/b/members", "/orgs/b/repos"},
+		CachedOrgEndpoints([]string{"a", "b"}))
+	assert.Equal(t, []string{"/", "/orgs/a", "/orgs/a/members", "/orgs/a/repos"}, CachedOrgEndpoints([]string{"a", "A", "a"}))
+}
+
+func TestDuplicateOrgs(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix", "netflix", "Netflix"})
+	assert.Equal(t, []string{"Netflix"}, s.orgs)
+	assert.NotPanics(t, func() { s.bootstrapHandler() }, "each org's routes are only registered once")
+	assert.Equal(t, http.StatusOK, tGet(s, "/view/netflix/bottom/1/stars").Code)
+}
+
+func TestReservedOrgs(t *testing.T) {
+	s := NewWithOrgs(datasource.NewCachedAPI(new(apiclient.ApiClientMock), zaptest.NewLogger(t).Sugar()),
+		zaptest.NewLogger(t).Sugar(), []string{"trending", "Netflix", "Bottom"})
+	assert.Equal(t, []string{"Netflix"}, s.orgs, "names of view routes can't be orgs")
+	_, ok := s.allowedOrg("trending")
+	assert.False(t, ok)
+}
+
+func TestViewBottomReposOrgs(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix", "Other"})
+	expected := `[["Netflix/CassJMeter",162]]`
+
+	w := tGet(s, "/view/bottom/1/stars")
+	assert.Equal(t, http.StatusOK, w.Code, "default org route")
+	assert.Equal(t, expected, w.Body.String())
+
+	w = tGet(s, "/view/other/bottom/1/stars")
+	assert.Equal(t, http.StatusOK, w.Code, "org lookups are case-insensitive")
+	assert.Equal(t, expected, w.Body.String())
+
+	w = tGet(s, "/view/Unknown/bottom/1/stars")
+	assert.Equal(t, http.StatusNotFound, w.Code, "orgs outside the allowlist 404")
+	m.AssertNotCalled(t, "Fetch")
+}
+
+func TestQueryForwarding(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+
+	m.On("Fetch", mock.Anything, "/search/repositories?q=foo&sort=stars").Return([]byte(`{"total_count":0}`), nil).Once()
+	w := tGet(s, "/search/repositories?sort=stars&q=foo")
+	assert.Equal(t, http.StatusOK, w.Code, "query is forwarded on proxied requests")
+
+	// Requesting a single page of a watched endpoint skips the flattened cache entry
+	m.On("Fetch", mock.Anything, "/orgs/Netflix/repos?page=2").Return([]byte(`[]`), nil).Once()
+	w = tGet(s, "/orgs/Netflix/repos?page=2&format=json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[]`, w.Body.String())
+
+	w = tGet(s, "/orgs/Netflix/repos?format=json")
+	assert.Equal(t, repoData(), w.Body.Bytes(), "format param isn't part of the cache key")
+	m.AssertExpectations(t)
+}
+
+// The whole stack against a fake github rather than a mocked client.
+func TestEndToEnd(t *testing.T) {
+	fake := fakegithub.New()
+	defer fake.Close()
+	fake.AddOrg(fakegithub.Org{Login: "Netflix", ID: 913567, Members: fakegithub.GenerateMembers("Netflix", 3), Repos: fakegithub.GenerateRepos("Netflix", 120)})
+	logger := zaptest.NewLogger(t).Sugar()
+	cache := datasource.NewCachedAPI(apiclient.NewGithubWithOptions(apiclient.GithubOptions{BaseURL: fake.URL}), logger)
+	s := NewWithOrgs(cache, logger, []string{"Netflix"})
+	for _, path := range s.CachedEndpoints() {
+		assert.Nil(t, cache.WatchEndpoint(path))
+	}
+	requests := len(fake.Requests())
+
+	w := tGet(s, "/view/bottom/2/stars")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[["Netflix/netflix-1",10],["Netflix/netflix-0",0]]`, w.Body.String())
+	w = tGet(s, "/orgs/Netflix/repos")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, CacheHit, w.Header().Get(HeaderCache))
+	w = tGet(s, "/orgs/Netflix/members")
+	assert.JSONEq(t, `[{"login":"netflix-member-0","id":5000,"type":"User"},{"login":"netflix-member-1","id":5001,"type":"User"},{"login":"netflix-member-2","id":5002,"type":"User"}]`, w.Body.String())
+	assert.Equal(t, requests, len(fake.Requests()), "cached routes don't go upstream")
+
+	fake.SetRepos("Netflix", fakegithub.GenerateRepos("Netflix", 1))
+	assert.Nil(t, cache.Refresh(OrgReposPath("Netflix")))
+	w = tGet(s, "/view/bottom/2/stars")
+	assert.Equal(t, `[["Netflix/netflix-0",0]]`, w.Body.String(), "views see refreshed data")
+}
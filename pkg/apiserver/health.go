@@ -0,0 +1,211 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/apiclient"
+)
+
+type HealthStatus string
+
+const (
+	HealthOk       HealthStatus = "ok"
+	HealthDegraded HealthStatus = "degraded" // Serving, but something needs looking at
+	HealthFailing  HealthStatus = "failing"  // Required data is missing or too old to be useful
+)
+
+// Worst of the two.
+func (h HealthStatus) worst(other HealthStatus) HealthStatus {
+	rank := map[HealthStatus]int{HealthOk: 0, HealthDegraded: 1, HealthFailing: 2}
+	if rank[other] > rank[h] {
+		return other
+	}
+	return h
+}
+
+// Thresholds for the health report, zero values fall back to the defaults.
+type HealthOptions struct {
+	Required     []string      // Endpoints that must be cached & fresh, defaults to the cached endpoints
+	DegradedAge  time.Duration // Required entries older than this are degraded, defaults to 3 update intervals
+	FailingAge   time.Duration // Required entries older than this are failing, defaults to 10 update intervals
+	MinRateLimit float64       // Share of the rate limit left below which upstream is degraded, defaults to 0.05
+	DegradedCode int           // Status code for a degraded report, defaults to 200 since cached data is still served
+}
+
+// Tune when /healthcheck reports degraded or failing. Must be called before Run().
+func (s *ApiServer) SetHealth(opts HealthOptions) {
+	s.health = opts
+}
+
+// Fills in the defaults that depend on the cache & orgs.
+func (s *ApiServer) healthOptions(interval time.Duration) HealthOptions {
+	opts := s.health
+	if len(opts.Required) == 0 {
+		opts.Required = s.CachedEndpoints()
+	}
+	if opts.DegradedAge <= 0 {
+		opts.DegradedAge = 3 * interval
+	}
+	if opts.FailingAge <= 0 {
+		opts.FailingAge = 10 * interval
+	}
+	if opts.MinRateLimit <= 0 {
+		opts.MinRateLimit = 0.05
+	}
+	if opts.DegradedCode == 0 {
+		opts.DegradedCode = http.StatusOK
+	}
+	return opts
+}
+
+type healthReport struct {
+	Status   HealthStatus     `json:"status"`
+	Problems []string         `json:"problems,omitempty"`
+	Updater  updaterHealth    `json:"updater"`
+	Upstream []upstreamHealth `json:"upstream"`
+	Entries  []entryHealth    `json:"entries"`
+}
+
+type updaterHealth struct {
+	Running     bool       `json:"running"`
+	Stalled     bool       `json:"stalled"`
+	IntervalSec int        `json:"interval_sec"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+}
+
+type upstreamHealth struct {
+	Name        string                 `json:"name"`
+	Circuit     apiclient.BreakerState `json:"circuit"`
+	Reachable   bool                   `json:"reachable"`
+	LastSuccess *time.Time             `json:"last_success,omitempty"`
+	LastFailure *time.Time             `json:"last_failure,omitempty"`
+	LastError   string                 `json:"last_error,omitempty"`
+	RateLimit   *apiclient.RateLimit   `json:"rate_limit,omitempty"`
+}
+
+type entryHealth struct {
+	Path        string       `json:"path"`
+	Status      HealthStatus `json:"status"`
+	Required    bool         `json:"required"`
+	Cached      bool         `json:"cached"`
+	AgeSec      int          `json:"age_sec"`
+	LastUpdated *time.Time   `json:"last_updated,omitempty"`
+	LastError   string       `json:"last_error,omitempty"`
+	LastErrorAt *time.Time   `json:"last_error_at,omitempty"`
+}
+
+// Nil for zero times so they're left out of the report.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Works out how the cache, its updater & upstream are doing.
+func (s *ApiServer) healthReport(now time.Time) healthReport {
+	updater := s.githubCachedAPI.UpdaterStatus()
+	opts := s.healthOptions(updater.Interval)
+	report := healthReport{Status: HealthOk, Upstream: []upstreamHealth{}, Entries: []entryHealth{}}
+	problem := func(status HealthStatus, format string, args ...any) {
+		report.Status = report.Status.worst(status)
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	report.Updater = updaterHealth{
+		Running:     updater.Running,
+		Stalled:     updater.Stalled(now),
+		IntervalSec: int(updater.Interval.Seconds()),
+		LastRun:     optionalTime(updater.LastRun),
+		NextRun:     optionalTime(updater.NextRun),
+	}
+	if report.Updater.Stalled {
+		problem(HealthFailing, "updater missed its run at %s", updater.NextRun.Format(time.RFC3339))
+	} else if !updater.Running {
+		problem(HealthDegraded, "updater isn't running")
+	}
+
+	for _, breaker := range s.breakers {
+		status := breaker.Status()
+		upstream := upstreamHealth{
+			Name:        breaker.Name(),
+			Circuit:     breaker.State(),
+			Reachable:   status.Reachable(),
+			LastSuccess: optionalTime(status.LastSuccess),
+			LastFailure: optionalTime(status.LastFailure),
+			LastError:   status.LastError,
+			RateLimit:   status.RateLimit,
+		}
+		report.Upstream = append(report.Upstream, upstream)
+		if upstream.Circuit != apiclient.BreakerClosed {
+			problem(HealthDegraded, "%s circuit %s", upstream.Name, upstream.Circuit)
+		}
+		if !upstream.Reachable {
+			problem(HealthDegraded, "%s unreachable: %s", upstream.Name, upstream.LastError)
+		}
+		if limit := status.RateLimit; limit != nil && now.Before(limit.Reset) &&
+			float64(limit.Remaining) < opts.MinRateLimit*float64(limit.Limit) {
+			problem(HealthDegraded, "%s has %d of %d requests left until %s", upstream.Name, limit.Remaining, limit.Limit, limit.Reset.Format(time.RFC3339))
+		}
+	}
+
+	required := map[string]bool{}
+	for _, path := range opts.Required {
+		entry, ok := s.githubCachedAPI.Entry(path)
+		if !ok {
+			report.Entries = append(report.Entries, entryHealth{Path: path, Status: HealthFailing, Required: true})
+			problem(HealthFailing, "%s isn't cached", path)
+			continue
+		}
+		required[entry.Path] = true
+	}
+	for _, entry := range s.githubCachedAPI.Entries() {
+		if !entry.Watched && !required[entry.Path] {
+			continue // Nobody expects these to be fresh
+		}
+		age := now.Sub(entry.LastUpdated)
+		health := entryHealth{
+			Path:        entry.Path,
+			Status:      HealthOk,
+			Required:    required[entry.Path],
+			Cached:      true,
+			AgeSec:      int(age.Seconds()),
+			LastUpdated: optionalTime(entry.LastUpdated),
+			LastError:   entry.LastError,
+			LastErrorAt: entry.LastErrorAt,
+		}
+		if age > opts.FailingAge {
+			health.Status = HealthFailing
+		} else if age > opts.DegradedAge {
+			health.Status = HealthDegraded
+		}
+		if health.Required && health.Status != HealthOk {
+			problem(health.Status, "%s not refreshed for %s", entry.Path, age.Round(time.Second))
+		}
+		report.Entries = append(report.Entries, health)
+	}
+	sort.Slice(report.Entries, func(i, j int) bool { return report.Entries[i].Path < report.Entries[j].Path })
+	return report
+}
+
+// JSON health report. Failing is a 503, degraded is a 200 unless configured otherwise
+// since cached data is still being served.
+func healthcheck(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := s.healthReport(time.Now())
+		code := http.StatusOK
+		switch report.Status {
+		case HealthDegraded:
+			code = s.healthOptions(0).DegradedCode
+		case HealthFailing:
+			code = http.StatusServiceUnavailable
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(code, report)
+	}
+}
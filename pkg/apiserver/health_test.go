@@ -0,0 +1,106 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+func tHealth(t *testing.T, s *ApiServer) (int, healthReport) {
+	w := tGet(s, "/healthcheck")
+	var report healthReport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func TestHealthEntries(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	s.SetHealth(HealthOptions{Required: []string{ApiPathNetflixOrgRepos}, DegradedAge: time.Minute, FailingAge: time.Hour})
+	s.githubCachedAPI.Run(time.Hour)
+	defer s.githubCachedAPI.Shutdown()
+
+	code, report := tHealth(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthOk, report.Status)
+	assert.True(t, report.Updater.Running)
+	assert.Equal(t, 3600, report.Updater.IntervalSec)
+	assert.Equal(t, []entryHealth{{
+		Path: ApiPathNetflixOrgRepos, Status: HealthOk, Required: true, Cached: true, LastUpdated: report.Entries[0].LastUpdated,
+	}}, report.Entries)
+
+	report = s.healthReport(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Equal(t, []string{"/orgs/Netflix/repos not refreshed for 2m0s"}, report.Problems)
+
+	report = s.healthReport(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, HealthFailing, report.Entries[0].Status)
+
+	// Stale entries that aren't required are reported but don't count
+	s.SetHealth(HealthOptions{Required: []string{"/rate_limit"}, DegradedAge: time.Minute})
+	code, report = tHealth(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"/rate_limit isn't cached"}, report.Problems)
+	assert.Len(t, report.Entries, 2)
+}
+
+func TestHealthUpdater(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	s.SetHealth(HealthOptions{Required: []string{ApiPathNetflixOrgRepos}, DegradedCode: http.StatusTooManyRequests})
+
+	code, report := tHealth(t, s)
+	assert.Equal(t, http.StatusTooManyRequests, code, "degraded status code is configurable")
+	assert.Equal(t, []string{"updater isn't running"}, report.Problems)
+
+	s.githubCachedAPI.Run(time.Minute)
+	defer s.githubCachedAPI.Shutdown()
+	assert.Equal(t, HealthOk, s.healthReport(time.Now()).Status)
+	report = s.healthReport(time.Now().Add(3 * time.Minute))
+	assert.True(t, report.Updater.Stalled)
+	assert.Equal(t, HealthFailing, report.Status)
+}
+
+func TestHealthUpstream(t *testing.T) {
+	remaining := "4000"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiclient.HeaderRateLimitLimit, "5000")
+		w.Header().Set(apiclient.HeaderRateLimitRemaining, remaining)
+		w.Header().Set(apiclient.HeaderRateLimitReset, "4102444800") // 2100
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	logger := zaptest.NewLogger(t).Sugar()
+	breaker := apiclient.NewBreaker(apiclient.NewGithubWithOptions(apiclient.GithubOptions{BaseURL: srv.URL}), apiclient.BreakerOptions{})
+	cache := datasource.NewCachedAPI(breaker, logger)
+	assert.Nil(t, cache.WatchEndpoint("/"))
+	s := New(cache, logger)
+	s.SetBreakers(breaker)
+	s.SetHealth(HealthOptions{Required: []string{"/"}})
+	cache.Run(time.Minute)
+	defer cache.Shutdown()
+
+	_, report := tHealth(t, s)
+	assert.Equal(t, HealthOk, report.Status)
+	assert.Equal(t, "github", report.Upstream[0].Name)
+	assert.True(t, report.Upstream[0].Reachable)
+	assert.Equal(t, 4000, report.Upstream[0].RateLimit.Remaining)
+
+	remaining = "10"
+	assert.Nil(t, cache.Refresh("/"))
+	code, report := tHealth(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Equal(t, []string{"github has 10 of 5000 requests left until 2100-01-01T00:00:00Z"}, report.Problems)
+
+	srv.Close()
+	assert.NotNil(t, cache.Refresh("/"))
+	_, report = tHealth(t, s)
+	assert.False(t, report.Upstream[0].Reachable)
+	assert.NotEmpty(t, report.Entries[0].LastError, "failed refreshes show against the entry")
+}
@@ -0,0 +1,148 @@
+package apiserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MetricClientRequests  = "nfcache_client_requests_total"
+	MetricCircuitState    = "nfcache_upstream_circuit_state"
+	MetricCircuitRejected = "nfcache_upstream_circuit_rejected_total"
+	MetricCircuitOpened   = "nfcache_upstream_circuit_opened_total"
+)
+
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+)
+
+// A series whose value is read when the metrics are rendered, for state owned elsewhere.
+type metricFunc struct {
+	kind   string
+	labels string
+	value  func() float64
+}
+
+// Minimal set of labelled counters, rendered in the prometheus text format. Safe for concurrent use.
+type Metrics struct {
+	lock     *sync.Mutex
+	counters map[string]map[string]uint64 // Metric name to rendered label set to count
+	funcs    map[string][]metricFunc
+	help     map[string]string
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		lock:     &sync.Mutex{},
+		counters: map[string]map[string]uint64{},
+		funcs:    map[string][]metricFunc{},
+		help: map[string]string{
+			MetricClientRequests:   "Requests by nfcache client and whether they were let through.",
+			MetricCircuitState:     "Upstream circuit breaker state, 0 closed, 1 open, 2 half-open.",
+			MetricCircuitRejected:  "Upstream calls failed fast by an open circuit breaker.",
+			MetricCircuitOpened:    "Times an upstream circuit breaker has tripped open.",
+			MetricEventSubscribers: "Clients connected to the /events stream.",
+		},
+	}
+}
+
+// Add one to the counter, labels are name/value pairs.
+func (m *Metrics) Inc(name string, labels ...string) {
+	key := renderLabels(labels)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]uint64{}
+	}
+	m.counters[name][key]++
+}
+
+// Current value of a counter, mostly for tests.
+func (m *Metrics) Value(name string, labels ...string) uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counters[name][renderLabels(labels)]
+}
+
+// Counter read from value on every scrape, value must only go up.
+func (m *Metrics) CounterFunc(name string, value func() float64, labels ...string) {
+	m.addFunc(name, metricCounter, value, labels)
+}
+
+// Gauge read from value on every scrape.
+func (m *Metrics) GaugeFunc(name string, value func() float64, labels ...string) {
+	m.addFunc(name, metricGauge, value, labels)
+}
+
+func (m *Metrics) addFunc(name string, kind string, value func() float64, labels []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.funcs[name] = append(m.funcs[name], metricFunc{kind: kind, labels: renderLabels(labels), value: value})
+}
+
+// Write every counter in the prometheus text exposition format, sorted so output is stable.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var b strings.Builder
+	names := map[string]bool{}
+	for name := range m.counters {
+		names[name] = true
+	}
+	for name := range m.funcs {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		if help, ok := m.help[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		}
+		if funcs, ok := m.funcs[name]; ok {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, funcs[0].kind)
+			for _, f := range funcs {
+				fmt.Fprintf(&b, "%s%s %g\n", name, f.labels, f.value())
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		for _, labels := range sortedKeys(m.counters[name]) {
+			fmt.Fprintf(&b, "%s%s %d\n", name, labels, m.counters[name][labels])
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func metricsHandler(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		s.metrics.WriteTo(c.Writer)
+	}
+}
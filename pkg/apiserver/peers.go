@@ -0,0 +1,40 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+// Hands our copy of a cache key to another replica, see datasource.Peers.
+func peerFetch(s *ApiServer, peers *datasource.Peers) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !peers.Authorized(c.Request) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		key := c.Query(datasource.PeerParamKey)
+		if key == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		data, err := s.githubCachedAPI.PeerFetch(key, c.Query(datasource.PeerParamRefresh) == "true")
+		if errors.Is(err, datasource.ErrNotCached) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if circuitOpen(c, err) {
+			s.reqLog(c).Warnf("Unable to serve %s to a peer: %v", key, err)
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			s.reqLog(c).Errorf("Unable to serve %s to a peer: %v", key, err)
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		c.Data(http.StatusOK, gin.MIMEJSON, data)
+	}
+}
@@ -0,0 +1,133 @@
+package apiserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+)
+
+type tReplica struct {
+	url    string
+	cache  *datasource.CachedAPI
+	client *apiclient.ApiClientMock
+	srv    *httptest.Server
+}
+
+// Upstream fetches the replica has made for the path.
+func (r *tReplica) fetches(path string) int {
+	count := 0
+	for _, call := range r.client.Calls {
+		if call.Method == "FetchAll" && call.Arguments.String(1) == path {
+			count++
+		}
+	}
+	return count
+}
+
+// In-process replicas sharing a cache, each with its own upstream mock.
+func tCluster(t *testing.T, n int) []*tReplica {
+	listeners := make([]net.Listener, n)
+	urls := make([]string, n)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %v", err)
+		}
+		listeners[i], urls[i] = l, "http://"+l.Addr().String()
+	}
+
+	replicas := make([]*tReplica, n)
+	for i := range replicas {
+		logger := zaptest.NewLogger(t).Sugar()
+		m := new(apiclient.ApiClientMock)
+		m.On("FetchAll", mock.Anything, mock.Anything).Return([]byte(`["data"]`), nil)
+		cache := datasource.NewCachedAPI(m, logger)
+		peers, err := datasource.NewPeers(urls[i], urls, "peer-secret")
+		assert.Nil(t, err)
+		cache.SetPeers(peers)
+
+		srv := &httptest.Server{Listener: listeners[i], Config: &http.Server{Handler: NewWithOrgs(cache, logger, nil).bootstrapHandler()}}
+		srv.Start()
+		t.Cleanup(srv.Close)
+		replicas[i] = &tReplica{url: urls[i], cache: cache, client: m, srv: srv}
+	}
+	return replicas
+}
+
+func TestPeerSharing(t *testing.T) {
+	replicas := tCluster(t, 3)
+	paths := []string{"/orgs/a", "/orgs/b", "/orgs/c", "/orgs/d", "/orgs/e", "/orgs/f"}
+	for _, r := range replicas {
+		for _, path := range paths {
+			assert.Nil(t, r.cache.WatchEndpoint(path))
+		}
+	}
+
+	for _, path := range paths {
+		owner := replicas[0].cache.Peers().Owner(path)
+		for _, r := range replicas {
+			expected := 0
+			if r.url == owner {
+				expected = 1
+			}
+			assert.Equal(t, expected, r.fetches(path), "only the owner of %s goes upstream", path)
+			entry, ok := r.cache.Entry(path)
+			assert.True(t, ok)
+			assert.True(t, entry.Watched)
+		}
+	}
+
+	w := tGet(NewWithOrgs(replicas[0].cache, zaptest.NewLogger(t).Sugar(), nil), datasource.PeerPath+"?key=/orgs/a")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "peer route needs the peer token")
+
+	// Misses are served but not watched, so peers can't add to the refresh load
+	req, err := http.NewRequest(http.MethodGet, replicas[0].url+datasource.PeerPath+"?key=/orgs/unwatched", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer peer-secret")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	entry, ok := replicas[0].cache.Entry("/orgs/unwatched")
+	assert.True(t, ok)
+	assert.False(t, entry.Watched)
+}
+
+func TestPeerFailover(t *testing.T) {
+	replicas := tCluster(t, 3)
+	// Find a key the first replica owns
+	var path string
+	for i := 0; path == ""; i++ {
+		if key := fmt.Sprintf("/orgs/%d", i); replicas[0].cache.Peers().Owner(key) == replicas[0].url {
+			path = key
+		}
+	}
+	for _, r := range replicas {
+		assert.Nil(t, r.cache.WatchEndpoint(path))
+	}
+	assert.Equal(t, 1, replicas[0].fetches(path))
+
+	replicas[0].srv.Close()
+	assert.Nil(t, replicas[1].cache.Refresh(path), "refresh fails over when the owner is down")
+	assert.Nil(t, replicas[2].cache.Refresh(path))
+
+	// Both replicas agree on the next owner on the ring, which takes over going upstream
+	newOwner := replicas[1].cache.Peers().Owner(path)
+	assert.Equal(t, newOwner, replicas[2].cache.Peers().Owner(path))
+	assert.NotEqual(t, replicas[0].url, newOwner)
+	for _, r := range replicas[1:] {
+		expected := 0
+		if r.url == newOwner {
+			expected = 2
+		}
+		assert.Equal(t, expected, r.fetches(path))
+	}
+}
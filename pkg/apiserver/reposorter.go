@@ -16,6 +16,15 @@ const (
 )
 
 func BottomNRepos(reposJSON []byte, field GithubSortField, numResults int) ([]byte, error) {
+	pairs, err := BottomNRepoPairs(reposJSON, field, numResults)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(pairs)
+}
+
+// Same as BottomNRepos but leaves encoding the result to the caller.
+func BottomNRepoPairs(reposJSON []byte, field GithubSortField, numResults int) ([]repoPair, error) {
 	if numResults < 0 {
 		numResults = 0
 	}
@@ -31,7 +40,7 @@ func BottomNRepos(reposJSON []byte, field GithubSortField, numResults int) ([]by
 		numResults = len(repos)
 	}
 
-	return sortedSliceToJSON(repos[len(repos)-numResults:], field)
+	return sortedSliceToPairs(repos[len(repos)-numResults:], field), nil
 }
 
 // Struct with custom marshaller to encode the return value [["repo",123],...]
@@ -45,7 +54,13 @@ func (r *repoPair) MarshalJSON() ([]byte, error) {
 	return json.Marshal(arr)
 }
 
-func sortedSliceToJSON(repos []GithubRepo, field GithubSortField) ([]byte, error) {
+// Object form of a repoPair, encodes as {"name":"repo","value":123}
+type repoObject struct {
+	Name  string `json:"name" yaml:"name"`
+	Value any    `json:"value" yaml:"value"`
+}
+
+func sortedSliceToPairs(repos []GithubRepo, field GithubSortField) []repoPair {
 	ret := make([]repoPair, len(repos))
 	for i, r := range repos {
 		var rp repoPair
@@ -62,7 +77,7 @@ func sortedSliceToJSON(repos []GithubRepo, field GithubSortField) ([]byte, error
 		}
 		ret[i] = rp
 	}
-	return json.Marshal(ret)
+	return ret
 }
 
 // To avoid keeping a fully typed repo struct up to date we only unpack the fields we care about
@@ -4,36 +4,146 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/apiclient"
 	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/timeseries"
 )
 
+const DefaultOrg = "Netflix"
+
 const (
-	ApiPathNetflixOrg        = "/orgs/Netflix"
+	ApiPathNetflixOrg        = "/orgs/" + DefaultOrg
 	ApiPathNetflixOrgMembers = ApiPathNetflixOrg + "/members"
 	ApiPathNetflixOrgRepos   = ApiPathNetflixOrg + "/repos"
 )
 
+func OrgPath(org string) string {
+	return "/orgs/" + org
+}
+
+func OrgMembersPath(org string) string {
+	return OrgPath(org) + "/members"
+}
+
+func OrgReposPath(org string) string {
+	return OrgPath(org) + "/repos"
+}
+
 type ApiServer struct {
 	githubCachedAPI *datasource.CachedAPI
 	log             *zap.SugaredLogger
 	httpServer      *http.Server
+
+	orgs       []string          // Allowlist of orgs to cache, the first is the default for views
+	orgLookups map[string]string // Lowercased org name to the configured name, github orgs are case-insensitive
+	adminToken string            // Admin routes are only served when this is set
+	hookSecret string            // Github webhooks are only accepted when this is set
+	clients    []*clientState    // Api keys for public routes, open access when empty
+	metrics    *Metrics
+	breakers   []*apiclient.Breaker // Upstream circuit breakers to report on
+	health     HealthOptions
+	trends     *timeseries.DB // Repo stats recorded on each refresh of the org repos
+	events     *eventHub      // Streams cache updates to /events subscribers
 }
 
+// Cached endpoints for the default org only.
 func CachedEndpoints() []string {
-	return []string{"/", ApiPathNetflixOrg, ApiPathNetflixOrgMembers, ApiPathNetflixOrgRepos}
+	return CachedOrgEndpoints([]string{DefaultOrg})
+}
+
+// The root api path plus the root, members and repos paths for each org.
+func CachedOrgEndpoints(orgs []string) []string {
+	endpoints := []string{"/"}
+	for _, org := range uniqueOrgs(orgs) {
+		endpoints = append(endpoints, OrgPath(org), OrgMembersPath(org), OrgReposPath(org))
+	}
+	return endpoints
+}
+
+// Drops repeats of an org, ignoring case as github does. The first spelling is kept.
+func uniqueOrgs(orgs []string) []string {
+	seen := make(map[string]bool, len(orgs))
+	unique := make([]string, 0, len(orgs))
+	for _, org := range orgs {
+		if key := strings.ToLower(org); !seen[key] {
+			seen[key] = true
+			unique = append(unique, org)
+		}
+	}
+	return unique
 }
 
 func New(githubCache *datasource.CachedAPI, logger *zap.SugaredLogger) *ApiServer {
-	return &ApiServer{
+	return NewWithOrgs(githubCache, logger, []string{DefaultOrg})
+}
+
+// View route segments that an org's name would clash with in /view/:org/...
+var reservedOrgs = map[string]bool{"bottom": true, "trending": true}
+
+// For serving views & cached endpoints for a set of orgs. Falls back to the default org if none are given.
+// Orgs named after a view route are rejected, their views couldn't be told apart from the default org's.
+func NewWithOrgs(githubCache *datasource.CachedAPI, logger *zap.SugaredLogger, orgs []string) *ApiServer {
+	allowed := make([]string, 0, len(orgs))
+	for _, org := range orgs {
+		if reservedOrgs[strings.ToLower(org)] {
+			logger.Errorf("Ignoring org %s, it clashes with the /view/%s routes", org, strings.ToLower(org))
+			continue
+		}
+		allowed = append(allowed, org)
+	}
+	orgs = allowed
+	if len(orgs) == 0 {
+		orgs = []string{DefaultOrg}
+	}
+	orgs = uniqueOrgs(orgs)
+	orgLookups := make(map[string]string, len(orgs))
+	for _, org := range orgs {
+		orgLookups[strings.ToLower(org)] = org
+	}
+	s := &ApiServer{
 		githubCachedAPI: githubCache,
 		log:             logger,
 		httpServer:      nil, // gets added when we start the server
+
+		orgs:       orgs,
+		orgLookups: orgLookups,
+		metrics:    NewMetrics(),
+		trends:     timeseries.New(timeseries.DefaultRetention),
+		events:     newEventHub(logger),
 	}
+	githubCache.OnUpdate(s.recordTrends)
+	githubCache.OnUpdate(s.events.publish)
+	s.metrics.GaugeFunc(MetricEventSubscribers, func() float64 { return float64(s.events.subscribers()) })
+	return s
+}
+
+// Enables the /admin routes, requests must send the token as a bearer token.
+// Must be called before Run().
+func (s *ApiServer) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// Enables the github webhook route, payloads must be signed with the secret.
+// Must be called before Run().
+func (s *ApiServer) SetWebhookSecret(secret string) {
+	s.hookSecret = secret
+}
+
+// Endpoints to watch for the orgs this server was configured with.
+func (s *ApiServer) CachedEndpoints() []string {
+	return CachedOrgEndpoints(s.orgs)
+}
+
+// Returns the configured name for the org if it's in the allowlist.
+func (s *ApiServer) allowedOrg(org string) (string, bool) {
+	name, ok := s.orgLookups[strings.ToLower(org)]
+	return name, ok
 }
 
 func (s *ApiServer) Run(address string) {
@@ -54,6 +164,7 @@ func (s *ApiServer) Shutdown(timeout time.Duration) {
 		s.log.Error("Called shutdown without a running http server")
 		return
 	}
+	s.events.close() // Event streams never finish on their own
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	if err := s.httpServer.Shutdown(ctx); err != nil {
@@ -64,15 +175,42 @@ func (s *ApiServer) Shutdown(timeout time.Duration) {
 
 func (s *ApiServer) bootstrapHandler() http.Handler {
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-	r.GET("/healthcheck", healthcheck)
+	r := gin.New()
+	r.Use(traceRequests(), accessLog(s.log), recovery(s.log))
+	r.GET("/healthcheck", healthcheck(s))
+
+	// views look like: /view/bottom/10/forks or /view/Netflix/bottom/10/forks
+	views := s.clientAuth(ScopeViews)
+	r.GET(fmt.Sprintf("/view/bottom/:%s/:%s", ParamNum, ParamSortAttribute), views, viewBottomRepos(s))
+	r.GET(fmt.Sprintf("/view/:%s/bottom/:%s/:%s", ParamOrg, ParamNum, ParamSortAttribute), views, viewBottomRepos(s))
+	r.GET(fmt.Sprintf("/view/trending/:%s/:%s", ParamWindow, ParamSortAttribute), views, viewTrendingRepos(s)) // e.g. /view/trending/7d/stars
+	r.GET(fmt.Sprintf("/view/:%s/trending/:%s/:%s", ParamOrg, ParamWindow, ParamSortAttribute), views, viewTrendingRepos(s))
 
-	// views look like: /view/bottom/10/forks
-	r.GET(fmt.Sprintf("/view/bottom/:%s/:%s", ParamNum, ParamSortAttribute), viewBottomRepos(s))
+	r.GET(fmt.Sprintf("/changes/*%s", ParamPath), views, viewChanges(s)) // e.g. /changes/orgs/Netflix/repos?since=24h
 
-	for _, path := range CachedEndpoints() {
-		r.GET(path, githubCachedFetch(s, path))
+	r.GET("/events", s.clientAuth(ScopeCached), streamEvents(s))
+	for _, path := range s.CachedEndpoints() {
+		r.GET(path, s.clientAuth(ScopeCached), githubCachedFetch(s, path))
 	}
-	r.NoRoute(githubProxyRequest(s)) // Proxy unknown urls instead of 404ing
+	if s.adminToken != "" {
+		admin := r.Group("/admin", adminAuth(s.adminToken))
+		admin.GET("/entries", adminListEntries(s))
+		admin.GET(fmt.Sprintf("/entries/*%s", ParamPath), adminGetEntry(s)) // e.g. /admin/entries/orgs/Netflix
+		admin.DELETE(fmt.Sprintf("/entries/*%s", ParamPath), adminEvict(s))
+		admin.POST(fmt.Sprintf("/watch/*%s", ParamPath), adminWatch(s))
+		admin.POST(fmt.Sprintf("/unwatch/*%s", ParamPath), adminUnwatch(s))
+		admin.POST(fmt.Sprintf("/refresh/*%s", ParamPath), adminRefresh(s))
+		admin.GET("/metrics", metricsHandler(s))
+	}
+
+	if s.hookSecret != "" {
+		r.POST("/webhooks/github", githubWebhook(s, s.hookSecret))
+	}
+
+	if peers := s.githubCachedAPI.Peers(); peers != nil {
+		r.GET(datasource.PeerPath, peerFetch(s, peers))
+	}
+
+	r.NoRoute(s.clientAuth(ScopeProxy), githubProxyRequest(s)) // Proxy unknown urls instead of 404ing
 	return r.Handler()
 }
@@ -0,0 +1,41 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span for each request, continuing the caller's trace if they sent a traceparent header.
+// Named after the matched route so spans group by handler, proxied requests share one name.
+func traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method + " proxy"
+		}
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.HTTPTarget(c.Request.URL.Path),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap/zaptest"
+)
+
+// Records spans in memory for the length of the test.
+func tTracer(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	tracing.SetPropagator()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return exporter
+}
+
+func tSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no %s span in %v", name, exporter.GetSpans())
+	return tracetest.SpanStub{}
+}
+
+func tAttr(span tracetest.SpanStub, key string) attribute.Value {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// Fake github with two pages of repos that remembers the traceparent of each request.
+type tracedGithub struct {
+	lock         sync.Mutex
+	traceparents []string
+}
+
+func (g *tracedGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	g.traceparents = append(g.traceparents, r.Header.Get("traceparent"))
+	g.lock.Unlock()
+	if r.URL.Path == "/orgs/Netflix/repos" && r.URL.Query().Get("page") == "1" {
+		w.Header().Set("Link", `<http://github/orgs/Netflix/repos?page=2>; rel="next"`)
+		w.Write([]byte(`[{"name":"a"}]`))
+		return
+	}
+	if r.URL.Path == "/orgs/Netflix/repos" {
+		w.Write([]byte(`[{"name":"b"}]`))
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+func tTracedServer(t *testing.T) (*ApiServer, *tracedGithub) {
+	github := &tracedGithub{}
+	srv := httptest.NewServer(github)
+	t.Cleanup(srv.Close)
+	logger := zaptest.NewLogger(t).Sugar()
+	client := apiclient.NewGithubWithOptions(apiclient.GithubOptions{BaseURL: srv.URL})
+	return NewWithOrgs(datasource.NewCachedAPI(client, logger), logger, nil), github
+}
+
+func TestTracingProxiedRequest(t *testing.T) {
+	exporter := tTracer(t)
+	s, github := tTracedServer(t)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/rate_limit", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.bootstrapHandler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	handler := tSpan(t, exporter, "GET proxy")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handler.SpanContext.TraceID().String(), "continues the caller's trace")
+	assert.Equal(t, "00f067aa0ba902b7", handler.Parent.SpanID().String())
+	assert.Equal(t, int64(http.StatusOK), tAttr(handler, "http.status_code").AsInt64())
+
+	fetch := tSpan(t, exporter, "CachedAPI.Fetch")
+	assert.Equal(t, handler.SpanContext.SpanID(), fetch.Parent.SpanID())
+	assert.Equal(t, "/rate_limit", tAttr(fetch, "cache.key").AsString())
+	assert.False(t, tAttr(fetch, "cache.hit").AsBool())
+
+	upstream := tSpan(t, exporter, "GithubClient.request")
+	assert.Equal(t, fetch.SpanContext.SpanID(), upstream.Parent.SpanID())
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + upstream.SpanContext.SpanID().String() + "-01"
+	assert.Equal(t, []string{expected}, github.traceparents, "trace is passed on to github")
+}
+
+func TestTracingRefreshAndHit(t *testing.T) {
+	exporter := tTracer(t)
+	s, _ := tTracedServer(t)
+	assert.Nil(t, s.githubCachedAPI.WatchEndpoint(ApiPathNetflixOrgRepos))
+
+	update := tSpan(t, exporter, "CachedAPI.updateEndpoint")
+	assert.Equal(t, ApiPathNetflixOrgRepos, tAttr(update, "cache.key").AsString())
+	fetchAll := tSpan(t, exporter, "GithubClient.FetchAll")
+	assert.Equal(t, update.SpanContext.SpanID(), fetchAll.Parent.SpanID())
+	assert.Equal(t, int64(2), tAttr(fetchAll, "github.pages").AsInt64())
+	var pages []string
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "GithubClient.request" {
+			assert.Equal(t, fetchAll.SpanContext.SpanID(), span.Parent.SpanID())
+			pages = append(pages, tAttr(span, "github.page").AsString())
+		}
+	}
+	assert.Equal(t, []string{"1", "2"}, pages, "a span per page")
+
+	exporter.Reset()
+	w := tGet(s, ApiPathNetflixOrgRepos)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, tAttr(tSpan(t, exporter, "CachedAPI.Fetch"), "cache.hit").AsBool())
+	assert.Equal(t, ApiPathNetflixOrgRepos, tAttr(tSpan(t, exporter, "GET "+ApiPathNetflixOrgRepos), "http.route").AsString())
+}
@@ -0,0 +1,163 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/timeseries"
+)
+
+const (
+	ParamWindow = "window"
+
+	DefaultTrendingNum = 10
+)
+
+// Numeric repo fields recorded on each refresh, keyed by their view name.
+var trendFields = map[string]func(r *GithubRepo) int64{
+	"stars":       func(r *GithubRepo) int64 { return int64(r.Stars) },
+	"forks":       func(r *GithubRepo) int64 { return int64(r.Forks) },
+	"open_issues": func(r *GithubRepo) int64 { return int64(r.Issues) },
+}
+
+// Series name for a field of a repo, repos are keyed by full name.
+func trendKey(repo string, field string) string {
+	return repo + "#" + field
+}
+
+// Keep the trend series somewhere other than memory, e.g. a timeseries.Open db so they survive restarts.
+// Must be called before Run() and before the org repos are watched.
+func (s *ApiServer) SetTrends(db *timeseries.DB) {
+	s.trends = db
+}
+
+// Cache update hook that records the numeric fields of each repo when an org's repos are refreshed.
+func (s *ApiServer) recordTrends(update datasource.Update) {
+	if !s.isOrgReposPath(update.Path) {
+		return
+	}
+	var repos []GithubRepo
+	if err := json.Unmarshal(update.Data, &repos); err != nil {
+		s.log.Errorf("Unable to record trends for %s: %v", update.Path, err)
+		return
+	}
+	values := make(map[string]int64, len(repos)*len(trendFields))
+	for i := range repos {
+		for field, value := range trendFields {
+			values[trendKey(repos[i].Name, field)] = value(&repos[i])
+		}
+	}
+	if err := s.trends.Record(update.LastUpdated, values); err != nil {
+		s.log.Errorf("Unable to save trends for %s: %v", update.Path, err)
+	}
+}
+
+func (s *ApiServer) isOrgReposPath(path string) bool {
+	for _, org := range s.orgs {
+		if path == OrgReposPath(org) {
+			return true
+		}
+	}
+	return false
+}
+
+// Like time.ParseDuration but also takes days & weeks, e.g. 7d or 2w.
+func parseWindow(window string) (time.Duration, bool) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if count, ok := strings.CutSuffix(window, suffix); ok {
+			n, err := strconv.Atoi(count)
+			return time.Duration(n) * unit, err == nil && n > 0
+		}
+	}
+	d, err := time.ParseDuration(window)
+	return d, err == nil && d > 0
+}
+
+// Repos ranked by how much a field grew over a window, e.g. /view/trending/7d/stars.
+// Repos without history back to the start of the window are measured from their first recorded value.
+func viewTrendingRepos(s *ApiServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org := s.orgs[0]
+		if param := c.Param(ParamOrg); param != "" {
+			var ok bool
+			if org, ok = s.allowedOrg(param); !ok {
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+		}
+		field := c.Param(ParamSortAttribute)
+		fieldValue, ok := trendFields[field]
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		window, ok := parseWindow(c.Param(ParamWindow))
+		if !ok || window > s.trends.Retention() {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		numResults, err := strconv.Atoi(c.DefaultQuery(ParamNum, strconv.Itoa(DefaultTrendingNum)))
+		if err != nil || numResults < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		format, ok := negotiateFormat(c)
+		if !ok {
+			return
+		}
+		shape, ok := viewShape(c)
+		if !ok {
+			return
+		}
+
+		repos, err := s.githubCachedAPI.FetchPayload(c.Request.Context(), OrgReposPath(org), "")
+		if err != nil {
+			s.fetchFailed(c, "trending data for "+org, err)
+			return
+		}
+		var current []GithubRepo
+		if err := json.Unmarshal(repos.Data, &current); err != nil {
+			s.reqLog(c).Errorf("Trending repos for %s failed with: %v", org, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		since := time.Now().Add(-window)
+		growth := make(map[string]int64, len(current))
+		pairs := make([]repoPair, 0, len(current))
+		for i := range current {
+			name, value := current[i].Name, fieldValue(&current[i])
+			if start, ok := s.trends.ValueAt(trendKey(name, field), since); ok {
+				growth[name] = value - start
+			} else {
+				growth[name] = 0 // Nothing recorded for the repo yet
+			}
+			pairs = append(pairs, repoPair{Name: name, Value: growth[name]})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			if growth[pairs[i].Name] != growth[pairs[j].Name] {
+				return growth[pairs[i].Name] > growth[pairs[j].Name]
+			}
+			return strings.ToLower(pairs[i].Name) < strings.ToLower(pairs[j].Name)
+		})
+		if numResults < len(pairs) {
+			pairs = pairs[:numResults]
+		}
+
+		s.setCacheHeaders(c, repos)
+		body, err := encodeRepoPairs(pairs, format, shape, field)
+		if err != nil {
+			s.reqLog(c).Errorf("Encoding trending view as %s failed with: %v", format, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Vary", "Accept")
+		c.Data(http.StatusOK, format.ContentType(), body)
+	}
+}
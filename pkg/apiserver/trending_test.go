@@ -0,0 +1,41 @@
+package apiserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/timeseries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestViewTrendingRepos(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetTrends(timeseries.New(timeseries.DefaultRetention))
+	day := 24 * time.Hour
+	s.trends.Record(time.Now().Add(-8*day), map[string]int64{
+		"Netflix/astyanax#stars": 1000, "Netflix/curator#stars": 2000, "Netflix/Priam#stars": 1020})
+	s.trends.Record(time.Now().Add(-3*day), map[string]int64{"Netflix/astyanax#stars": 1020})
+
+	// Refreshes record the latest values
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return(repoData(), nil).Once()
+	assert.Nil(t, s.githubCachedAPI.Refresh(ApiPathNetflixOrgRepos))
+	points := s.trends.Points("Netflix/astyanax#stars")
+	assert.Equal(t, int64(1027), points[len(points)-1].Value)
+
+	w := tGet(s, "/view/trending/7d/stars?num=3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[["Netflix/curator",138],["Netflix/astyanax",27],["Netflix/Priam",4]]`, w.Body.String())
+	w = tGet(s, "/view/Netflix/trending/24h/stars?num=2&shape=object")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"name":"Netflix/curator","value":138},{"name":"Netflix/astyanax","value":7}]`, w.Body.String())
+	w = tGet(s, "/view/trending/1w/forks?num=1")
+	assert.Equal(t, `[["Netflix/archaius",0]]`, w.Body.String(), "repos without history haven't grown")
+
+	assert.Equal(t, http.StatusBadRequest, tGet(s, "/view/trending/7x/stars").Code)
+	assert.Equal(t, http.StatusBadRequest, tGet(s, "/view/trending/60d/stars").Code, "longer than the retention")
+	assert.Equal(t, http.StatusBadRequest, tGet(s, "/view/trending/7d/stars?num=-1").Code)
+	assert.Equal(t, http.StatusNotFound, tGet(s, "/view/trending/7d/last_updated").Code)
+	assert.Equal(t, http.StatusNotFound, tGet(s, "/view/Other/trending/7d/stars").Code)
+}
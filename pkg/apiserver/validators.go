@@ -0,0 +1,68 @@
+package apiserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Strong ETag for one representation of a data version.
+// Each format & content encoding is a different representation so gets its own tag.
+func entityTag(version string, variants ...string) string {
+	tag := version
+	for _, v := range variants {
+		if v != "" {
+			tag += "-" + v
+		}
+	}
+	return `"` + tag + `"`
+}
+
+// Short hash of the request params that pick a view, so each view over the same data gets its own tag.
+func viewTag(params ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(params, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Set the ETag & Last-Modified validators then check the request's conditional headers.
+// Responds with a 304 and returns true if the client's copy is current.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence when both are sent, RFC 9110 13.2.2
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		// Last-Modified only has second precision so compare at that
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// Weak comparison of an If-None-Match list against our tag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityTag(t *testing.T) {
+	assert.Equal(t, `"abc"`, entityTag("abc"))
+	assert.Equal(t, `"abc"`, entityTag("abc", "", ""))
+	assert.Equal(t, `"abc-csv-gzip"`, entityTag("abc", "csv", "gzip"))
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`"xyz", W/"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`*`, `"abc"`))
+	assert.False(t, etagMatches(`"abc-gzip"`, `"abc"`))
+}
+
+func TestConditionalRequests(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	send := func(url string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		s.bootstrapHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	for _, url := range []string{ApiPathNetflixOrgRepos, "/view/bottom/2/stars"} {
+		w := send(url, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+		lastModified := w.Header().Get("Last-Modified")
+		assert.NotEmpty(t, etag)
+		assert.NotEmpty(t, lastModified)
+
+		w = send(url, map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code, url)
+		assert.Empty(t, w.Body.String())
+
+		w = send(url, map[string]string{"If-Modified-Since": lastModified})
+		assert.Equal(t, http.StatusNotModified, w.Code, url)
+
+		w = send(url, map[string]string{"If-Modified-Since": time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusOK, w.Code, url)
+
+		// If-None-Match wins over If-Modified-Since
+		w = send(url, map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": lastModified})
+		assert.Equal(t, http.StatusOK, w.Code, url)
+	}
+
+	// Different views over the same data don't share tags
+	view1 := send("/view/bottom/2/stars", nil).Header().Get("ETag")
+	view2 := send("/view/bottom/3/stars", nil).Header().Get("ETag")
+	view3 := send("/view/bottom/2/stars?format=csv", nil).Header().Get("ETag")
+	assert.NotEqual(t, view1, view2)
+	assert.NotEqual(t, view1, view3)
+}
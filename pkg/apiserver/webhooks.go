@@ -0,0 +1,133 @@
+package apiserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/njo/nfcache/pkg/datasource"
+)
+
+const (
+	HeaderGithubEvent     = "X-GitHub-Event"
+	HeaderGithubSignature = "X-Hub-Signature-256"
+	MaxWebhookBytes       = 25 << 20 // Github caps payloads at 25MB
+)
+
+// Actions per event type that change data we cache.
+var webhookActions = map[string]map[string]bool{
+	"repository": {"created": true, "deleted": true, "renamed": true, "archived": true,
+		"unarchived": true, "transferred": true, "publicized": true, "privatized": true},
+	"organization": {"member_added": true, "member_removed": true},
+	"star":         {"created": true, "deleted": true},
+}
+
+// The parts of a webhook payload needed to work out which paths are affected.
+type webhookPayload struct {
+	Action       string `json:"action"`
+	Organization struct {
+		Login string `json:"login"`
+	} `json:"organization"`
+	Repository struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// Receives github webhooks and refreshes the cached paths affected by the event.
+// Refreshes happen in the background so github isn't kept waiting on our upstream calls.
+func githubWebhook(s *ApiServer, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxWebhookBytes))
+		if err != nil {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !validSignature(secret, c.GetHeader(HeaderGithubSignature), body) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		event := c.GetHeader(HeaderGithubEvent)
+		if event == "ping" {
+			c.String(http.StatusOK, "pong")
+			return
+		}
+		paths, err := s.webhookPaths(event, body)
+		if err != nil {
+			s.reqLog(c).Errorf("Unable to parse %s webhook: %v", event, err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		log := s.reqLog(c) // The gin context can't be used once the handler returns
+		for _, path := range paths {
+			log.Infof("Refreshing %s for %s webhook", path, event)
+			go func(path string) {
+				err := s.githubCachedAPI.Refresh(path)
+				if err != nil && !errors.Is(err, datasource.ErrNotCached) {
+					log.Errorf("Webhook refresh of %s failed with: %v", path, err)
+				}
+			}(path)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"refreshing": paths})
+	}
+}
+
+// Checks the sha256 hmac github sends in the form "sha256=<hex digest>".
+func validSignature(secret string, header string, body []byte) bool {
+	digest, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	signature, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// Map a webhook event to the cached paths it invalidates.
+// Events we don't track or for orgs outside the allowlist return no paths.
+func (s *ApiServer) webhookPaths(event string, body []byte) ([]string, error) {
+	actions, ok := webhookActions[event]
+	if !ok {
+		return nil, nil
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if !actions[payload.Action] {
+		return nil, nil
+	}
+
+	login := payload.Organization.Login
+	if login == "" {
+		login = payload.Repository.Owner.Login
+	}
+	org, ok := s.allowedOrg(login)
+	if !ok {
+		return nil, nil
+	}
+
+	switch event {
+	case "repository": // The org root has the public repo count
+		return []string{OrgPath(org), OrgReposPath(org)}, nil
+	case "organization":
+		return []string{OrgPath(org), OrgMembersPath(org)}, nil
+	case "star":
+		return []string{OrgReposPath(org)}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,134 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const tWebhookSecret = "It's a Secret to Everybody"
+
+// Recorded github payload from testdata/webhooks.
+func tPayload(t *testing.T, name string) []byte {
+	payload, err := os.ReadFile(filepath.Join("testdata", "webhooks", name+".json"))
+	if err != nil {
+		t.Fatalf("unable to load payload %s: %v", name, err)
+	}
+	return payload
+}
+
+func tSign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func tWebhook(s *ApiServer, event string, payload []byte, signature string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(payload))
+	req.Header.Set(HeaderGithubEvent, event)
+	req.Header.Set(HeaderGithubSignature, signature)
+	s.bootstrapHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestWebhookPaths(t *testing.T) {
+	s, _ := tServer(t, []string{"netflix"})
+	cases := []struct {
+		event   string
+		payload string
+		paths   []string
+	}{
+		{"repository", "repository_created", []string{"/orgs/netflix", "/orgs/netflix/repos"}},
+		{"repository", "repository_renamed", []string{"/orgs/netflix", "/orgs/netflix/repos"}},
+		{"repository", "repository_deleted", []string{"/orgs/netflix", "/orgs/netflix/repos"}},
+		{"repository", "repository_archived", []string{"/orgs/netflix", "/orgs/netflix/repos"}},
+		{"organization", "organization_member_added", []string{"/orgs/netflix", "/orgs/netflix/members"}},
+		{"organization", "organization_member_removed", []string{"/orgs/netflix", "/orgs/netflix/members"}},
+		{"star", "star_created", []string{"/orgs/netflix/repos"}},
+		{"push", "star_created", nil},
+	}
+	for _, tc := range cases {
+		paths, err := s.webhookPaths(tc.event, tPayload(t, tc.payload))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.paths, paths, "%s event from %s", tc.event, tc.payload)
+	}
+
+	other, _ := tServer(t, []string{"Other"})
+	paths, err := other.webhookPaths("star", tPayload(t, "star_created"))
+	assert.Nil(t, err)
+	assert.Empty(t, paths, "orgs outside the allowlist are ignored")
+}
+
+func TestWebhookSignature(t *testing.T) {
+	s, _ := tServer(t, []string{"Netflix"})
+	s.SetWebhookSecret(tWebhookSecret)
+	payload := tPayload(t, "ping")
+
+	assert.Equal(t, http.StatusUnauthorized, tWebhook(s, "ping", payload, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, tWebhook(s, "ping", payload, tSign("wrong", payload)).Code)
+	assert.Equal(t, http.StatusUnauthorized, tWebhook(s, "ping", payload, "sha256=nothex").Code)
+	assert.Equal(t, http.StatusOK, tWebhook(s, "ping", payload, tSign(tWebhookSecret, payload)).Code)
+}
+
+func TestWebhookRefresh(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetWebhookSecret(tWebhookSecret)
+	payload := tPayload(t, "star_created")
+
+	before, _ := s.githubCachedAPI.Entry(ApiPathNetflixOrgRepos)
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return(repoData(), nil).Once()
+	w := tWebhook(s, "star", payload, tSign(tWebhookSecret, payload))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"refreshing":["/orgs/Netflix/repos"]}`, w.Body.String())
+	assert.Eventually(t, func() bool {
+		after, _ := s.githubCachedAPI.Entry(ApiPathNetflixOrgRepos)
+		return after.LastUpdated.After(before.LastUpdated)
+	}, time.Second, 10*time.Millisecond, "webhook didn't refresh the repos")
+}
+
+func TestWebhookDeletedRepo(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetWebhookSecret(tWebhookSecret)
+	payload := tPayload(t, "repository_deleted")
+
+	// Only the cached repos are refetched, the uncached org root is skipped
+	m.On("FetchAll", mock.Anything, ApiPathNetflixOrgRepos).Return([]byte(`[]`), nil).Once()
+	w := tWebhook(s, "repository", payload, tSign(tWebhookSecret, payload))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"refreshing":["/orgs/Netflix","/orgs/Netflix/repos"]}`, w.Body.String())
+	assert.Eventually(t, func() bool {
+		after, _ := s.githubCachedAPI.Entry(ApiPathNetflixOrgRepos)
+		return after.Size == len(`[]`)
+	}, time.Second, 10*time.Millisecond, "webhook didn't drop the deleted repo")
+	m.AssertNotCalled(t, "FetchAll", mock.Anything, ApiPathNetflixOrg)
+}
+
+func TestWebhookEvictedPaths(t *testing.T) {
+	s, m := tServer(t, []string{"Netflix"})
+	s.SetWebhookSecret(tWebhookSecret)
+	assert.Nil(t, s.githubCachedAPI.Evict(ApiPathNetflixOrgRepos))
+
+	for _, tc := range []struct{ event, payload string }{
+		{"repository", "repository_archived"},
+		{"organization", "organization_member_removed"},
+	} {
+		payload := tPayload(t, tc.payload)
+		assert.Equal(t, http.StatusAccepted, tWebhook(s, tc.event, payload, tSign(tWebhookSecret, payload)).Code)
+	}
+	assert.Never(t, func() bool {
+		_, ok := s.githubCachedAPI.Entry(ApiPathNetflixOrgRepos)
+		return ok
+	}, 100*time.Millisecond, 10*time.Millisecond, "webhooks don't bring back evicted entries")
+	m.AssertNumberOfCalls(t, "FetchAll", 1) // Only the initial watch
+}
@@ -0,0 +1,161 @@
+// Package changes works out what changed between two versions of a github payload.
+package changes
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// What changed between two versions. Repo lists get a repo by repo summary,
+// anything else gets a JSON Patch.
+type Diff struct {
+	Repos *RepoDiff   `json:"repos,omitempty"`
+	Patch []Operation `json:"patch,omitempty"`
+}
+
+// Nothing changed between the versions.
+func (d Diff) Empty() bool {
+	if d.Repos != nil {
+		return len(d.Repos.Added)+len(d.Repos.Removed)+len(d.Repos.Renamed)+len(d.Repos.Changed) == 0
+	}
+	return len(d.Patch) == 0
+}
+
+// Changes to a list of repos, matched up by id so renames aren't an add & a remove.
+type RepoDiff struct {
+	Added   []RepoRef    `json:"added"`
+	Removed []RepoRef    `json:"removed"`
+	Renamed []RepoRename `json:"renamed"`
+	Changed []RepoChange `json:"changed"`
+}
+
+type RepoRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type RepoRename struct {
+	ID   int64  `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Top level fields that differ for a repo in both versions, keyed by field name.
+type RepoChange struct {
+	ID     int64                 `json:"id"`
+	Name   string                `json:"name"` // Current name
+	Fields map[string]FieldDelta `json:"fields"`
+}
+
+type FieldDelta struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff two versions of a payload, both must be JSON.
+func Compare(from []byte, to []byte) (Diff, error) {
+	a, err := decode(from)
+	if err != nil {
+		return Diff{}, err
+	}
+	b, err := decode(to)
+	if err != nil {
+		return Diff{}, err
+	}
+	if repos, ok := repoList(a); ok {
+		if next, ok := repoList(b); ok {
+			return Diff{Repos: compareRepos(repos, next)}, nil
+		}
+	}
+	return Diff{Patch: Patch(a, b)}, nil
+}
+
+// Numbers are kept as json.Number so ids & counts survive the round trip exactly.
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+type repo struct {
+	ref    RepoRef
+	fields map[string]any
+}
+
+// A list of objects that all have a numeric id & a name, as returned by /orgs/<org>/repos.
+func repoList(value any) (map[int64]repo, bool) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+	repos := make(map[int64]repo, len(list))
+	for _, item := range list {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		id, idOk := fields["id"].(json.Number)
+		name, nameOk := fields["name"].(string)
+		if !idOk || !nameOk {
+			return nil, false
+		}
+		intID, err := id.Int64()
+		if err != nil {
+			return nil, false
+		}
+		repos[intID] = repo{ref: RepoRef{ID: intID, Name: name}, fields: fields}
+	}
+	return repos, true
+}
+
+func compareRepos(from map[int64]repo, to map[int64]repo) *RepoDiff {
+	diff := &RepoDiff{Added: []RepoRef{}, Removed: []RepoRef{}, Renamed: []RepoRename{}, Changed: []RepoChange{}}
+	for id, old := range from {
+		if _, ok := to[id]; !ok {
+			diff.Removed = append(diff.Removed, old.ref)
+		}
+	}
+	for id, current := range to {
+		old, ok := from[id]
+		if !ok {
+			diff.Added = append(diff.Added, current.ref)
+			continue
+		}
+		fields := compareFields(old.fields, current.fields)
+		if old.ref.Name != current.ref.Name {
+			diff.Renamed = append(diff.Renamed, RepoRename{ID: id, From: old.ref.Name, To: current.ref.Name})
+			delete(fields, "name") // Already reported as the rename
+			delete(fields, "full_name")
+		}
+		if len(fields) > 0 {
+			diff.Changed = append(diff.Changed, RepoChange{ID: id, Name: current.ref.Name, Fields: fields})
+		}
+	}
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Name < diff.Added[j].Name })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Name < diff.Removed[j].Name })
+	sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].To < diff.Renamed[j].To })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Name < diff.Changed[j].Name })
+	return diff
+}
+
+// Fields missing from one side show up as null.
+func compareFields(from map[string]any, to map[string]any) map[string]FieldDelta {
+	deltas := map[string]FieldDelta{}
+	for field, old := range from {
+		if current, ok := to[field]; !ok || !reflect.DeepEqual(old, current) {
+			deltas[field] = FieldDelta{From: old, To: to[field]}
+		}
+	}
+	for field, current := range to {
+		if _, ok := from[field]; !ok {
+			deltas[field] = FieldDelta{From: nil, To: current}
+		}
+	}
+	return deltas
+}
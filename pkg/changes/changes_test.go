@@ -0,0 +1,70 @@
+package changes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareRepos(t *testing.T) {
+	from := []byte(`[
+		{"id": 1, "name": "zuul", "stargazers_count": 10},
+		{"id": 2, "name": "hystrix", "stargazers_count": 20},
+		{"id": 3, "name": "old-name", "full_name": "Netflix/old-name", "stargazers_count": 30}
+	]`)
+	to := []byte(`[
+		{"id": 1, "name": "zuul", "stargazers_count": 11, "archived": true},
+		{"id": 3, "name": "new-name", "full_name": "Netflix/new-name", "stargazers_count": 30},
+		{"id": 4, "name": "metaflow", "stargazers_count": 40}
+	]`)
+	diff, err := Compare(from, to)
+	assert.Nil(t, err)
+	assert.Nil(t, diff.Patch)
+	assert.Equal(t, []RepoRef{{ID: 4, Name: "metaflow"}}, diff.Repos.Added)
+	assert.Equal(t, []RepoRef{{ID: 2, Name: "hystrix"}}, diff.Repos.Removed)
+	assert.Equal(t, []RepoRename{{ID: 3, From: "old-name", To: "new-name"}}, diff.Repos.Renamed)
+	assert.Equal(t, []RepoChange{
+		{ID: 1, Name: "zuul", Fields: map[string]FieldDelta{
+			"stargazers_count": {From: json.Number("10"), To: json.Number("11")},
+			"archived":         {From: nil, To: true},
+		}},
+	}, diff.Repos.Changed, "renames aren't reported again as name changes")
+
+	body, err := json.Marshal(diff.Repos.Changed[0].Fields["stargazers_count"])
+	assert.Nil(t, err)
+	assert.Equal(t, `{"from":10,"to":11}`, string(body), "numbers are passed through as is")
+
+	diff, err = Compare(from, from)
+	assert.Nil(t, err)
+	assert.Equal(t, &RepoDiff{Added: []RepoRef{}, Removed: []RepoRef{}, Renamed: []RepoRename{}, Changed: []RepoChange{}}, diff.Repos)
+
+	_, err = Compare(from, []byte(`not json`))
+	assert.NotNil(t, err)
+}
+
+func TestComparePatch(t *testing.T) {
+	diff, err := Compare(
+		[]byte(`{"login": "Netflix", "public_repos": 1, "blog": "a", "plan": {"seats": 1}, "tags": ["a", "b", "c"]}`),
+		[]byte(`{"login": "Netflix", "public_repos": 2, "a/b~c": null, "plan": {"seats": 1}, "tags": ["a", "x"]}`))
+	assert.Nil(t, err)
+	assert.Nil(t, diff.Repos)
+	body, err := json.Marshal(diff.Patch)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"op": "remove", "path": "/blog"},
+		{"op": "add", "path": "/a~1b~0c", "value": null},
+		{"op": "replace", "path": "/public_repos", "value": 2},
+		{"op": "replace", "path": "/tags/1", "value": "x"},
+		{"op": "remove", "path": "/tags/2"}
+	]`, string(body))
+
+	// Lists of things that aren't repos, e.g. org members
+	diff, err = Compare([]byte(`[{"id": 1, "login": "a"}]`), []byte(`[{"id": 1, "login": "a"}, {"id": 2, "login": "b"}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Operation{{Op: "add", Path: "/1", Value: map[string]any{"id": json.Number("2"), "login": "b"}}}, diff.Patch)
+
+	diff, err = Compare([]byte(`"a"`), []byte(`"b"`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Operation{{Op: "replace", Path: "", Value: "b"}}, diff.Patch)
+}
@@ -0,0 +1,100 @@
+package changes
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A JSON Patch (RFC 6902) operation. Only add, remove & replace are generated.
+type Operation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"` // JSON Pointer (RFC 6901)
+	Value any    `json:"value"`
+}
+
+// Remove operations don't carry a value, everything else does even when it's null.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	type operation Operation // Drops this method so we don't recurse
+	return json.Marshal(operation(o))
+}
+
+// Operations that turn from into to, for decoded JSON values. Objects are compared key by key
+// & arrays index by index, so an insert near the start of an array shows up as a run of replaces.
+func Patch(from any, to any) []Operation {
+	ops := []Operation{}
+	return patch(ops, "", from, to)
+}
+
+func patch(ops []Operation, path string, from any, to any) []Operation {
+	switch a := from.(type) {
+	case map[string]any:
+		if b, ok := to.(map[string]any); ok {
+			return patchObject(ops, path, a, b)
+		}
+	case []any:
+		if b, ok := to.([]any); ok {
+			return patchArray(ops, path, a, b)
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, Operation{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+func patchObject(ops []Operation, path string, from map[string]any, to map[string]any) []Operation {
+	for _, key := range sortedKeys(from) {
+		if _, ok := to[key]; !ok {
+			ops = append(ops, Operation{Op: "remove", Path: path + "/" + escape(key)})
+		}
+	}
+	for _, key := range sortedKeys(to) {
+		if old, ok := from[key]; ok {
+			ops = patch(ops, path+"/"+escape(key), old, to[key])
+		} else {
+			ops = append(ops, Operation{Op: "add", Path: path + "/" + escape(key), Value: to[key]})
+		}
+	}
+	return ops
+}
+
+// Extra items are removed from the end backwards so earlier indexes stay valid.
+func patchArray(ops []Operation, path string, from []any, to []any) []Operation {
+	common := len(from)
+	if len(to) < common {
+		common = len(to)
+	}
+	for i := 0; i < common; i++ {
+		ops = patch(ops, path+"/"+strconv.Itoa(i), from[i], to[i])
+	}
+	for i := len(from) - 1; i >= common; i-- {
+		ops = append(ops, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+	}
+	for i := common; i < len(to); i++ {
+		ops = append(ops, Operation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: to[i]})
+	}
+	return ops
+}
+
+// JSON Pointer escaping, ~ first so the ~1 from / isn't double escaped.
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,31 +2,99 @@ package datasource
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/requestid"
+	"github.com/njo/nfcache/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 const DefaultFetchTimeoutSec = 30
 const DefaultUpdateIntervalSec = 60
+const DefaultHistorySize = 100 // Versions kept per watched entry
 
+var ErrNotCached = errors.New("path is not cached")
+
+// A cached response and its metadata.
 type ApiData struct {
-	lastUpdated time.Time
-	data        []byte
+	EntryMeta
+	Data    []byte
+	Encoded map[string][]byte // Precompressed copies of Data keyed by content encoding
+}
+
+// Data returned from the cache along with its metadata.
+// Live fetches only have Data & LastUpdated set.
+type Payload struct {
+	Data        []byte
+	Encoding    string    // Content encoding of Data, "" when it's uncompressed
+	Cached      bool      // False when the data was fetched live
+	Version     string    // Hash of the uncompressed data
+	LastUpdated time.Time // When the data was fetched from upstream
+
+	Stale  bool          // Cached data that's missed at least one refresh
+	MaxAge time.Duration // How long until the data is due to be refreshed, 0 if it isn't
+}
+
+// Uses the precompressed copy of the data for the encoding if there is one.
+// Caller must hold the read lock.
+func (c *CachedAPI) payload(entry *ApiData, encoding string) Payload {
+	p := Payload{Data: entry.Data, Cached: true, Version: entry.Version, LastUpdated: entry.LastUpdated}
+	if data, ok := entry.Encoded[encoding]; ok {
+		p.Data, p.Encoding = data, encoding
+	}
+
+	// Give refreshes the time it takes to fetch before calling them late
+	age := time.Since(entry.LastUpdated)
+	p.Stale = age > c.interval+DefaultFetchTimeoutSec*time.Second
+	if entry.Watched && age < c.interval {
+		p.MaxAge = c.interval - age
+	}
+	return p
+}
+
+// Metadata about a cached entry, doesn't include the data itself.
+type EntryInfo struct {
+	Path        string         `json:"path"`
+	Size        int            `json:"size"`
+	Version     string         `json:"version"`
+	EncodedSize map[string]int `json:"encoded_size,omitempty"`
+	LastUpdated time.Time      `json:"last_updated"`
+	Watched     bool           `json:"watched"`
+	LastError   string         `json:"last_error,omitempty"`
+	LastErrorAt *time.Time     `json:"last_error_at,omitempty"`
+	NextRefresh *time.Time     `json:"next_refresh,omitempty"` // Only set for watched entries once Run() is called
 }
 
 // API Cache that passes through requests on cache misses to underlying API.
 // Will keep cached URLs up to date automatically if Run() is called.
 // Safe for concurrent use.
 type CachedAPI struct {
-	client apiclient.ApiClient // Client is threadsafe
-	log    *zap.SugaredLogger
+	client        apiclient.ApiClient // Client is threadsafe, used for cache misses
+	refreshClient apiclient.ApiClient // Used to update cached entries, can be the same as client
+	log           *zap.SugaredLogger
 
-	cachedData map[string]*ApiData // Not theadsafe, coordinate with rwMutex
+	store      Store                     // Threadsafe on its own, the rwMutex makes read-modify-writes atomic
+	nextUpdate time.Time                 // When the updater will next refresh watched entries, also uses rwMutex
+	interval   time.Duration             // How often the updater runs, also uses rwMutex
+	lastUpdate time.Time                 // When the updater last kicked off refreshes, also uses rwMutex
+	updating   map[string]*pendingUpdate // Keys with updates in flight, lets them spot an eviction. Uses rwMutex
 	lock       *sync.RWMutex
 
+	misses      *singleflight.Group // Coalesces concurrent fetches for uncached paths
+	peers       *Peers              // Replicas sharing the cache, nil when running alone
+	historySize int                 // Snapshots kept per watched entry, 0 to keep none
+	onUpdate    []UpdateFunc        // Called after each successful update
+
 	// Pieces to coordinate the updater goroutine
 	done    chan struct{}
 	wg      *sync.WaitGroup
@@ -34,12 +102,31 @@ type CachedAPI struct {
 }
 
 func NewCachedAPI(client apiclient.ApiClient, logger *zap.SugaredLogger) *CachedAPI {
+	return NewCachedAPIWithRefreshClient(client, client, logger)
+}
+
+// Use a separate client for keeping cached entries up to date, e.g. so refreshes
+// and proxied traffic draw on different rate limits.
+func NewCachedAPIWithRefreshClient(client apiclient.ApiClient, refreshClient apiclient.ApiClient, logger *zap.SugaredLogger) *CachedAPI {
+	return NewCachedAPIWithStore(client, refreshClient, NewMemoryStore(), logger)
+}
+
+// Keep entries in the given store, e.g. a DiskStore so they survive restarts.
+// Entries already in the store are served & kept up to date like any others.
+// The caller is responsible for closing the store after Shutdown().
+func NewCachedAPIWithStore(client apiclient.ApiClient, refreshClient apiclient.ApiClient, store Store, logger *zap.SugaredLogger) *CachedAPI {
 	provider := &CachedAPI{
-		client: client,
-		log:    logger,
+		client:        client,
+		refreshClient: refreshClient,
+		log:           logger,
 
-		cachedData: make(map[string]*ApiData),
-		lock:       &sync.RWMutex{},
+		store:    store,
+		interval: DefaultUpdateIntervalSec * time.Second,
+		updating: map[string]*pendingUpdate{},
+		lock:     &sync.RWMutex{},
+
+		misses:      &singleflight.Group{},
+		historySize: DefaultHistorySize,
 
 		done:    make(chan struct{}),
 		wg:      &sync.WaitGroup{},
@@ -48,40 +135,125 @@ func NewCachedAPI(client apiclient.ApiClient, logger *zap.SugaredLogger) *Cached
 	return provider
 }
 
+// Share the cache with other replicas. Only the owner of a key refreshes it from upstream,
+// the rest copy it from the owner. Must be called before watching endpoints or Run().
+func (c *CachedAPI) SetPeers(peers *Peers) {
+	c.peers = peers
+}
+
+// How many versions of each watched entry to keep, 0 turns history off.
+// Must be called before watching endpoints or Run().
+func (c *CachedAPI) SetHistorySize(size int) {
+	c.historySize = size
+}
+
+// Details of a successful update, see OnUpdate.
+type Update struct {
+	Path        string
+	Version     string
+	Changed     bool // False when upstream returned the same data as before
+	Watched     bool
+	LastUpdated time.Time
+	Data        []byte // Must not be modified
+
+	PreviousVersion string // "" for new entries
+	Previous        []byte // Data before the update, nil for new entries. Must not be modified
+}
+
+type UpdateFunc func(Update)
+
+// Call fn after every successful update, from the goroutine that made it. Slow functions hold up
+// that refresh so should hand off anything expensive. Must be called before watching endpoints or Run().
+func (c *CachedAPI) OnUpdate(fn UpdateFunc) {
+	c.onUpdate = append(c.onUpdate, fn)
+}
+
+// Nil when the cache isn't shared.
+func (c *CachedAPI) Peers() *Peers {
+	return c.peers
+}
+
+// Where an update gets its data from.
+type updateSource int
+
+const (
+	fromOwner      updateSource = iota // The owner's copy, or upstream if we own the key
+	fromOwnerFresh                     // Have the owner refresh from upstream first
+	fromUpstream                       // Skip the peers entirely
+)
+
+func (s updateSource) String() string {
+	switch s {
+	case fromOwner:
+		return "owner"
+	case fromOwnerFresh:
+		return "owner_fresh"
+	default:
+		return "upstream"
+	}
+}
+
 // Runs in a thread to keep the cache up to date until stopped with c.Done.
 // Cache updates are done in parallel their own goroutines.
 func (c *CachedAPI) dataUpdater(updateInterval time.Duration) {
 	c.wg.Add(1)
 	defer c.wg.Done()
 	ticker := time.NewTicker(updateInterval)
+	c.setNextUpdate(time.Now().UTC().Add(updateInterval))
 	for {
 		select {
 		case <-c.done:
 			c.log.Debug("dataUpdater worker exited")
+			c.setNextUpdate(time.Time{})
 			return
 		case <-ticker.C:
-			c.lock.RLock() // We don't hold this long since we update in goroutines
-			for path := range c.cachedData {
+			c.lock.Lock() // We don't hold this long since we update in goroutines
+			c.lastUpdate = time.Now().UTC()
+			c.nextUpdate = c.lastUpdate.Add(updateInterval)
+			metas, err := c.store.List()
+			if err != nil {
+				c.log.Errorf("Issue listing cache entries: %v", err)
+			}
+			for path, meta := range metas {
+				if !meta.Watched {
+					continue
+				}
 				// Keep in mind if updateEndpoint() is changed to block this will deadlock.
 				// Can simply copy the paths to a separate slice first to avoid this.
-				go c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second)
+				go c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second, fromOwner, true)
 			}
-			c.lock.RUnlock()
+			c.lock.Unlock()
 		}
 	}
 }
 
-// Update (or add) the given path into the cache.
-func (c *CachedAPI) updateEndpoint(path string, timeout time.Duration) error {
+func (c *CachedAPI) setNextUpdate(next time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.nextUpdate = next
+}
+
+// Update (or add) the given path into the cache. New entries are only watched if watchNew is set,
+// existing entries keep their watched status.
+func (c *CachedAPI) updateEndpoint(path string, timeout time.Duration, source updateSource, watchNew bool) error {
 	c.wg.Add(1)
 	defer c.wg.Done()
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	ctx, span := tracing.Tracer().Start(ctx, "CachedAPI.updateEndpoint",
+		trace.WithAttributes(attribute.String("cache.key", path), attribute.String("cache.source", source.String())))
+	defer span.End()
+
+	pending, evictions := c.startUpdate(path)
+	defer c.finishUpdate(path)
 
-	pageData, err := c.client.FetchAll(ctx, path)
+	pageData, err := c.load(ctx, path, source)
 	if err != nil {
-		c.log.Error("Issue fetching %s: %v", path, err)
+		c.log.Errorf("Issue fetching %s: %v", path, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.recordError(path, err)
 		return err
 	}
 
@@ -90,16 +262,105 @@ func (c *CachedAPI) updateEndpoint(path string, timeout time.Duration) error {
 		return nil
 	}
 
+	// Compress before taking the lock, this is the expensive part of an update
+	encoded, err := precompress(pageData)
+	if err != nil {
+		// Still worth caching, clients just get the uncompressed data
+		c.log.Errorf("Issue compressing %s: %v", path, err)
+	}
+
+	entry := &ApiData{
+		EntryMeta: EntryMeta{
+			LastUpdated: time.Now().UTC(),
+			Version:     contentVersion(pageData),
+			Size:        len(pageData),
+			EncodedSize: encodedSizes(encoded),
+			Watched:     watchNew,
+		},
+		Data:    pageData,
+		Encoded: encoded,
+	}
+
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	// Clients receiving data from the old buffer will be able to complete the read before GC cleans up.
-	c.cachedData[path] = &ApiData{time.Now().UTC(), pageData}
+	if pending.evictions != evictions {
+		// Evicted while we were fetching, putting it back would undo the purge
+		c.lock.Unlock()
+		c.log.Infof("Dropped update for %s as it was evicted", path)
+		return nil
+	}
+	changed := true
+	existing, err := c.store.Get(path)
+	if err == nil {
+		entry.Watched = existing.Watched
+		changed = existing.Version != entry.Version
+	}
+	if err := c.store.Put(path, entry); err != nil {
+		c.lock.Unlock()
+		c.log.Errorf("Issue storing %s: %v", path, err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if changed && entry.Watched && c.historySize > 0 {
+		if err := c.store.AddSnapshot(path, snapshot(entry), c.historySize); err != nil {
+			c.log.Errorf("Issue recording history for %s: %v", path, err)
+		}
+	}
+	c.lock.Unlock()
 	c.log.Debugf("Updated %s", path)
+
+	// Hooks run without the lock so they're free to read from the cache
+	update := Update{Path: path, Version: entry.Version, Changed: changed, Watched: entry.Watched, LastUpdated: entry.LastUpdated, Data: entry.Data}
+	if existing != nil {
+		update.PreviousVersion, update.Previous = existing.Version, existing.Data
+	}
+	for _, fn := range c.onUpdate {
+		fn(update)
+	}
 	return nil
 }
 
+// Fetch the latest data for the path. Keys owned by another peer are copied from the owner,
+// unreachable owners are skipped for a while and the next peer on the ring is asked instead.
+func (c *CachedAPI) load(ctx context.Context, path string, source updateSource) ([]byte, error) {
+	for c.peers != nil && source != fromUpstream {
+		owner := c.peers.Owner(path)
+		if owner == "" || c.peers.IsSelf(owner) {
+			break
+		}
+		data, err := c.peers.Fetch(ctx, owner, path, source == fromOwnerFresh)
+		if err == nil || !peerUnreachable(err) || ctx.Err() != nil {
+			return data, err
+		}
+		// Terminates since we never skip ourselves
+		c.log.Warnf("Peer %s unreachable for %s, skipping it for %v: %v", owner, path, PeerRetryInterval, err)
+		c.peers.markDown(owner)
+	}
+	return c.refreshClient.FetchAll(ctx, path)
+}
+
+// Note the failed refresh against the entry, the previous data is kept.
+func (c *CachedAPI) recordError(path string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, getErr := c.store.Get(path)
+	if getErr != nil {
+		return
+	}
+	// Swap in a copy rather than mutate, readers may still hold the old entry
+	updated := *entry
+	updated.LastError = err.Error()
+	updated.LastErrorAt = time.Now().UTC()
+	if putErr := c.store.Put(path, &updated); putErr != nil {
+		c.log.Errorf("Issue storing %s: %v", path, putErr)
+	}
+}
+
 // Run the auto updater in another thread. Non-Blocking.
 func (c *CachedAPI) Run(updateInterval time.Duration) {
+	c.lock.Lock()
+	c.interval = updateInterval
+	c.nextUpdate = time.Now().UTC().Add(updateInterval) // Shows as running straight away
+	c.lock.Unlock()
 	go c.dataUpdater(updateInterval)
 	c.running = true
 }
@@ -117,23 +378,361 @@ func (c *CachedAPI) Shutdown() {
 }
 
 // If the endpoint isn't being cached, fetch the endpoint, cache it and auto-update.
+// Entries that are cached but not watched start being auto-updated again.
 func (c *CachedAPI) WatchEndpoint(path string) error {
+	path = WatchKey(path)
+	if c.setWatched(path, true) == nil {
+		return nil // Already cached
+	}
+	return c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second, fromOwner, true)
+}
+
+// Stop auto-updating the endpoint. The cached data is still served until it's evicted.
+func (c *CachedAPI) UnwatchEndpoint(path string) error {
+	return c.setWatched(path, false)
+}
+
+func (c *CachedAPI) setWatched(path string, watched bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key, entry, err := c.getEntry(path)
+	if err != nil {
+		return err
+	}
+	if entry.Watched != watched {
+		updated := *entry
+		updated.Watched = watched
+		return c.store.Put(key, &updated)
+	}
+	return nil
+}
+
+// The entry for the path & the key it's stored under. Falls back to the watch key, which drops
+// pagination params, so a path finds the entry it was watched with. Caller must hold the read lock.
+func (c *CachedAPI) getEntry(path string) (string, *ApiData, error) {
+	key := CacheKey(path)
+	entry, err := c.store.Get(key)
+	if watchKey := WatchKey(path); errors.Is(err, ErrNotCached) && watchKey != key {
+		key = watchKey
+		entry, err = c.store.Get(key)
+	}
+	return key, entry, err
+}
+
+// Updates in flight for a key. Only kept while there are some so evicted keys don't pile up.
+type pendingUpdate struct {
+	running   int
+	evictions uint64 // Times the key was evicted while updates were running
+}
+
+// Registers an update for the path, returning its pending state & the evictions seen so far.
+func (c *CachedAPI) startUpdate(path string) (*pendingUpdate, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pending, ok := c.updating[path]
+	if !ok {
+		pending = &pendingUpdate{}
+		c.updating[path] = pending
+	}
+	pending.running++
+	return pending, pending.evictions
+}
+
+func (c *CachedAPI) finishUpdate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if pending := c.updating[path]; pending != nil {
+		if pending.running--; pending.running == 0 {
+			delete(c.updating, path)
+		}
+	}
+}
+
+// Drop the endpoint from the cache, future requests are proxied directly until it's watched again.
+// Refreshes already in flight for the path are dropped rather than adding it back.
+func (c *CachedAPI) Evict(path string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	path, _, err := c.getEntry(path)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Delete(path); err != nil {
+		return err
+	}
+	if pending, ok := c.updating[path]; ok {
+		pending.evictions++
+	}
+	c.log.Infof("Evicted %s", path)
+	return nil
+}
+
+// Update a cached endpoint now rather than waiting for the updater. Blocks until the fetch completes.
+// When the cache is shared the owner refreshes from upstream and we take a copy.
+func (c *CachedAPI) Refresh(path string) error {
 	c.lock.RLock()
-	_, ok := c.cachedData[path]
+	path, _, err := c.getEntry(path)
 	c.lock.RUnlock()
-	if ok {
-		return nil // Already being watched
+	if err != nil {
+		return err
 	}
-	return c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second)
+	return c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second, fromOwnerFresh, true)
+}
+
+// Data for a key requested by another peer. Misses are loaded from upstream and kept as read-through
+// entries, a peer can't make us refresh arbitrary keys every interval. Only WatchEndpoint does that.
+// Peer requests are never passed on to another peer so replicas that disagree about ownership can't loop.
+func (c *CachedAPI) PeerFetch(path string, refresh bool) ([]byte, error) {
+	path = CacheKey(path)
+	if !refresh {
+		c.lock.RLock()
+		entry, err := c.store.Get(path)
+		c.lock.RUnlock()
+		if err == nil {
+			return entry.Data, nil
+		}
+	}
+
+	// Several peers asking at once share one upstream fetch
+	_, err, _ := c.misses.Do(PeerPath+path, func() (any, error) {
+		return nil, c.updateEndpoint(path, DefaultFetchTimeoutSec*time.Second, fromUpstream, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, err := c.store.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Data, nil
 }
 
 // Fetch the path from the cache if it's there, otherwise proxy directly from api.
+// Paths can include a query string, see CacheKey for how they're matched against the cache.
+// Concurrent misses for the same path share a single upstream request.
 func (c *CachedAPI) Fetch(ctx context.Context, path string) ([]byte, error) {
+	payload, err := c.FetchPayload(ctx, path, "")
+	if err != nil {
+		return nil, err
+	}
+	return payload.Data, nil
+}
+
+// Same as Fetch but includes the cache metadata for the data.
+// Returns a precompressed copy of cached data if one exists for the encoding.
+func (c *CachedAPI) FetchPayload(ctx context.Context, path string, encoding string) (Payload, error) {
+	path = CacheKey(path)
+	ctx, span := tracing.Tracer().Start(ctx, "CachedAPI.Fetch", trace.WithAttributes(attribute.String("cache.key", path)))
+	defer span.End()
+	payload, err := c.fetchPayload(ctx, path, encoding)
+	span.SetAttributes(attribute.Bool("cache.hit", payload.Cached))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return payload, err
+}
+
+func (c *CachedAPI) fetchPayload(ctx context.Context, path string, encoding string) (Payload, error) {
+	c.lock.RLock()
+	cachedPage, err := c.store.Get(path)
+	var payload Payload
+	if err == nil {
+		payload = c.payload(cachedPage, encoding)
+	}
+	c.lock.RUnlock()
+	if err == nil {
+		return payload, nil
+	}
+	log := requestid.Logger(ctx, c.log)
+	if !errors.Is(err, ErrNotCached) {
+		// Still worth trying upstream if the store is having trouble
+		log.Errorf("Issue reading %s from the cache store: %v", path, err)
+	}
+	log.Debugf("Cache miss for %s", path)
+
+	// Cache miss, direct fetch. The shared request outlives any single caller giving up
+	// so it gets its own timeout rather than the first caller's cancellation.
+	result := c.misses.DoChan(path, func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(detachedContext{ctx}, DefaultFetchTimeoutSec*time.Second)
+		defer cancel()
+		return c.client.Fetch(fetchCtx, path)
+	})
+	select {
+	case <-ctx.Done():
+		return Payload{}, ctx.Err()
+	case r := <-result:
+		if r.Err != nil {
+			return Payload{}, r.Err
+		}
+		return Payload{Data: r.Val.([]byte), LastUpdated: time.Now().UTC()}, nil
+	}
+}
+
+// Keeps the values from the parent context (e.g. the request ID) but not its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (d detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (d detachedContext) Done() <-chan struct{}       { return nil }
+func (d detachedContext) Err() error                  { return nil }
+func (d detachedContext) Value(key any) any           { return d.parent.Value(key) }
+
+// State of the background updater, see Run().
+type UpdaterStatus struct {
+	Running  bool
+	Interval time.Duration
+	LastRun  time.Time // Zero until the first scheduled refresh
+	NextRun  time.Time // Zero when the updater isn't running
+}
+
+// The updater is running but has missed a whole interval, e.g. because its goroutine is stuck.
+func (u UpdaterStatus) Stalled(now time.Time) bool {
+	return u.Running && now.After(u.NextRun.Add(u.Interval))
+}
+
+func (c *CachedAPI) UpdaterStatus() UpdaterStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return UpdaterStatus{
+		Running:  !c.nextUpdate.IsZero(), // Set while the updater goroutine is alive
+		Interval: c.interval,
+		LastRun:  c.lastUpdate,
+		NextRun:  c.nextUpdate,
+	}
+}
+
+// Metadata for every cached entry, sorted by path.
+func (c *CachedAPI) Entries() []EntryInfo {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	cachedPage, ok := c.cachedData[path]
-	if !ok { // cache miss, direct fetch
-		return c.client.Fetch(ctx, path)
+	metas, err := c.store.List()
+	if err != nil {
+		c.log.Errorf("Issue listing cache entries: %v", err)
+	}
+	entries := make([]EntryInfo, 0, len(metas))
+	for path, meta := range metas {
+		entries = append(entries, c.entryInfo(path, meta))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// Metadata for a single cached entry. Returns false if the path isn't cached.
+func (c *CachedAPI) Entry(path string) (EntryInfo, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	path, entry, err := c.getEntry(path)
+	if err != nil {
+		return EntryInfo{}, false
+	}
+	return c.entryInfo(path, entry.EntryMeta), true
+}
+
+// Caller must hold the read lock.
+func (c *CachedAPI) entryInfo(path string, meta EntryMeta) EntryInfo {
+	info := EntryInfo{
+		Path:        path,
+		Size:        meta.Size,
+		Version:     meta.Version,
+		EncodedSize: meta.EncodedSize,
+		LastUpdated: meta.LastUpdated,
+		Watched:     meta.Watched,
+		LastError:   meta.LastError,
+	}
+	if meta.LastError != "" {
+		errAt := meta.LastErrorAt
+		info.LastErrorAt = &errAt
+	}
+	if meta.Watched && !c.nextUpdate.IsZero() {
+		next := c.nextUpdate
+		info.NextRefresh = &next
+	}
+	return info
+}
+
+// Keeps the gzip copy where there is one, it's the cheapest to read back.
+func snapshot(entry *ApiData) Snapshot {
+	snap := Snapshot{Version: entry.Version, Taken: entry.LastUpdated, Data: entry.Data}
+	if data, ok := entry.Encoded[EncodingGzip]; ok {
+		snap.Encoding, snap.Data = EncodingGzip, data
+	}
+	return snap
+}
+
+// A version in an entry's history.
+type VersionInfo struct {
+	Version string    `json:"version"`
+	Taken   time.Time `json:"taken"`
+}
+
+// A watched entry's kept versions oldest first, loaded in one go so several versions can be read from it.
+type EntryHistory []Snapshot
+
+func (h EntryHistory) Versions() []VersionInfo {
+	versions := make([]VersionInfo, 0, len(h))
+	for _, snap := range h {
+		versions = append(versions, VersionInfo{Version: snap.Version, Taken: snap.Taken})
+	}
+	return versions
+}
+
+// Data for a version. Returns ErrNotCached if it's not in the history.
+func (h EntryHistory) Data(version string) ([]byte, error) {
+	for _, snap := range h {
+		if snap.Version == version {
+			return decompress(snap.Encoding, snap.Data)
+		}
+	}
+	return nil, ErrNotCached
+}
+
+// Every kept version of a watched entry with its data. Returns ErrNotCached if there's no history for the path.
+func (c *CachedAPI) LoadHistory(path string) (EntryHistory, error) {
+	snapshots, err := c.store.Snapshots(WatchKey(path))
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, ErrNotCached
+	}
+	return snapshots, nil
+}
+
+// Versions of a watched entry oldest first. Returns ErrNotCached if there's no history for the path.
+func (c *CachedAPI) History(path string) ([]VersionInfo, error) {
+	history, err := c.LoadHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	return history.Versions(), nil
+}
+
+// Data for a version from the entry's history. Returns ErrNotCached if it's not in the history.
+func (c *CachedAPI) VersionData(path string, version string) ([]byte, error) {
+	history, err := c.LoadHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	return history.Data(version)
+}
+
+// Strong validator for the data, a truncated sha256 is plenty to tell versions apart.
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+func encodedSizes(encoded map[string][]byte) map[string]int {
+	if len(encoded) == 0 {
+		return nil
+	}
+	sizes := make(map[string]int, len(encoded))
+	for encoding, data := range encoded {
+		sizes[encoding] = len(data)
 	}
-	return cachedPage.data, nil
+	return sizes
 }
@@ -2,9 +2,15 @@ package datasource
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/fakegithub"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
@@ -51,3 +57,310 @@ func TestCachedApi(t *testing.T) {
 	assert.Equal(t, r, response3)
 	assert.Nil(t, e)
 }
+
+func TestCachedApiEntries(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/myendpoint"
+
+	_, ok := cache.Entry(path)
+	assert.False(t, ok)
+
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	entry, ok := cache.Entry(path)
+	assert.True(t, ok)
+	assert.Equal(t, path, entry.Path)
+	assert.Equal(t, 14, entry.Size)
+	assert.True(t, entry.Watched)
+	assert.Empty(t, entry.LastError)
+	assert.Nil(t, entry.NextRefresh, "updater isn't running")
+
+	// A failed refresh keeps the old data and records the error
+	m.On("FetchAll", mock.Anything, path).Return([]byte(nil), errors.New("upstream down")).Once()
+	assert.NotNil(t, cache.updateEndpoint(path, time.Second, fromOwner, true))
+	entries := cache.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "upstream down", entries[0].LastError)
+	assert.NotNil(t, entries[0].LastErrorAt)
+	assert.Equal(t, entry.LastUpdated, entries[0].LastUpdated)
+
+	cache.Run(time.Hour)
+	defer cache.Shutdown()
+	assert.Eventually(t, func() bool {
+		entry, _ := cache.Entry(path)
+		return entry.NextRefresh != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCachedApiWatchLifecycle(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	c := context.Background()
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/myendpoint"
+
+	assert.ErrorIs(t, cache.UnwatchEndpoint(path), ErrNotCached)
+	assert.ErrorIs(t, cache.Refresh(path), ErrNotCached)
+	assert.ErrorIs(t, cache.Evict(path), ErrNotCached)
+
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+
+	// Unwatched entries keep serving and refreshing doesn't re-watch them
+	assert.Nil(t, cache.UnwatchEndpoint(path))
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["Second Call"]`), nil).Once()
+	assert.Nil(t, cache.Refresh(path))
+	entry, _ := cache.Entry(path)
+	assert.False(t, entry.Watched)
+	r, e := cache.Fetch(c, path)
+	assert.Equal(t, []byte(`["Second Call"]`), r)
+	assert.Nil(t, e)
+
+	// Watching a cached entry doesn't refetch
+	assert.Nil(t, cache.WatchEndpoint(path))
+	entry, _ = cache.Entry(path)
+	assert.True(t, entry.Watched)
+
+	// Evicted entries are proxied
+	assert.Nil(t, cache.Evict(path))
+	m.On("Fetch", mock.Anything, path).Return([]byte(`["Live Call"]`), nil).Once()
+	r, e = cache.Fetch(c, path)
+	assert.Equal(t, []byte(`["Live Call"]`), r)
+	assert.Nil(t, e)
+	m.AssertExpectations(t)
+}
+
+func TestCachedApiPaginatedWatch(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/orgs/x/repos?per_page=100&type=public"
+
+	m.On("FetchAll", mock.Anything, "/orgs/x/repos?type=public").Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	entry, ok := cache.Entry(path)
+	assert.True(t, ok, "found with the params it was watched with")
+	assert.Equal(t, "/orgs/x/repos?type=public", entry.Path)
+
+	assert.Nil(t, cache.UnwatchEndpoint(path))
+	entry, _ = cache.Entry(path)
+	assert.False(t, entry.Watched)
+	m.On("FetchAll", mock.Anything, "/orgs/x/repos?type=public").Return([]byte(`["Second Call"]`), nil).Once()
+	assert.Nil(t, cache.Refresh(path))
+	assert.Nil(t, cache.Evict(path))
+	_, ok = cache.Entry("/orgs/x/repos?type=public")
+	assert.False(t, ok)
+	m.AssertExpectations(t)
+}
+
+func TestCachedApiEvictDuringRefresh(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/poisoned"
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["Bad"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	m.On("FetchAll", mock.Anything, path).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return([]byte(`["Still bad"]`), nil).Once()
+	done := make(chan error)
+	go func() { done <- cache.Refresh(path) }()
+
+	<-started
+	assert.Nil(t, cache.Evict(path))
+	close(release)
+	assert.Nil(t, <-done)
+	_, ok := cache.Entry(path)
+	assert.False(t, ok, "the in-flight refresh doesn't add it back")
+	assert.Empty(t, cache.updating, "nothing is kept once updates finish")
+
+	// Watching again after the eviction caches it as normal
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["Good"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	_, ok = cache.Entry(path)
+	assert.True(t, ok)
+	assert.Nil(t, cache.Evict(path))
+	assert.Empty(t, cache.updating, "evictions without updates in flight aren't tracked")
+	m.AssertExpectations(t)
+}
+
+// Signals once the caller first waits on Done, which Fetch only does after joining the shared request.
+type tJoinedContext struct {
+	context.Context
+	joined chan<- struct{}
+	once   sync.Once
+}
+
+func (c *tJoinedContext) Done() <-chan struct{} {
+	c.once.Do(func() { c.joined <- struct{}{} })
+	return c.Context.Done()
+}
+
+func TestCachedApiCoalescesMisses(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/uncached"
+	response := []byte(`["Shared Call"]`)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.On("Fetch", mock.Anything, path).Return(response, nil).Once().Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	})
+
+	results := make(chan []byte)
+	joined := make(chan struct{}, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			r, _ := cache.Fetch(&tJoinedContext{Context: context.Background(), joined: joined}, path)
+			results <- r
+		}()
+	}
+	<-started
+
+	// The upstream call doesn't hold the cache lock so writers aren't blocked
+	m.On("FetchAll", mock.Anything, "/watched").Return([]byte(`["Watched"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint("/watched"))
+
+	// A caller giving up doesn't cancel the shared request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e := cache.Fetch(ctx, path)
+	assert.ErrorIs(t, e, context.Canceled)
+
+	for i := 0; i < 5; i++ {
+		<-joined // Every caller is waiting on the in-flight request
+	}
+	close(release)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, response, <-results)
+	}
+	m.AssertNumberOfCalls(t, "Fetch", 1)
+}
+
+func TestCachedApiPayloadFreshness(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/myendpoint"
+
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	p, e := cache.FetchPayload(context.Background(), path, "")
+	assert.Nil(t, e)
+	assert.True(t, p.Cached)
+	assert.False(t, p.Stale)
+	assert.InDelta(t, DefaultUpdateIntervalSec, p.MaxAge.Seconds(), 1)
+
+	// Backdate the entry past its refresh interval plus the fetch timeout
+	entry, _ := cache.store.Get(path)
+	backdated := *entry
+	backdated.LastUpdated = time.Now().Add(-(DefaultUpdateIntervalSec + DefaultFetchTimeoutSec + 1) * time.Second)
+	assert.Nil(t, cache.store.Put(path, &backdated))
+	p, _ = cache.FetchPayload(context.Background(), path, "")
+	assert.True(t, p.Stale)
+	assert.Zero(t, p.MaxAge)
+
+	m.On("Fetch", mock.Anything, "/live").Return([]byte(`["Live"]`), nil).Once()
+	p, _ = cache.FetchPayload(context.Background(), "/live", "")
+	assert.False(t, p.Cached)
+	assert.False(t, p.LastUpdated.IsZero())
+}
+
+func TestCachedApiHistory(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	cache.SetHistorySize(2)
+	path := "/myendpoint"
+	large := []byte(`["` + strings.Repeat("a", MinCompressSize) + `"]`)
+
+	_, err := cache.History(path)
+	assert.ErrorIs(t, err, ErrNotCached)
+
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Once()
+	assert.Nil(t, cache.Refresh(path))
+	history, err := cache.History(path)
+	assert.Nil(t, err)
+	assert.Len(t, history, 1, "unchanged data isn't a new version")
+
+	m.On("FetchAll", mock.Anything, path).Return(large, nil).Once()
+	assert.Nil(t, cache.Refresh(path))
+	entry, _ := cache.Entry(path)
+	history, _ = cache.History(path)
+	assert.Len(t, history, 2)
+	assert.Equal(t, entry.Version, history[1].Version)
+	data, err := cache.VersionData(path, entry.Version)
+	assert.Nil(t, err)
+	assert.Equal(t, large, data, "compressed snapshots read back as the original")
+	data, err = cache.VersionData(path, history[0].Version)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`["First Call"]`), data)
+
+	// Unwatched entries aren't tracked
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["Third Call"]`), nil).Once()
+	assert.Nil(t, cache.UnwatchEndpoint(path))
+	assert.Nil(t, cache.Refresh(path))
+	history, _ = cache.History(path)
+	assert.Len(t, history, 2)
+	_, err = cache.VersionData(path, "missing")
+	assert.ErrorIs(t, err, ErrNotCached)
+}
+
+func TestCachedApiOnUpdate(t *testing.T) {
+	m := new(apiclient.ApiClientMock)
+	cache := NewCachedAPI(m, tLog(t))
+	path := "/myendpoint"
+	var updates []Update
+	cache.OnUpdate(func(u Update) { updates = append(updates, u) })
+
+	m.On("FetchAll", mock.Anything, path).Return([]byte(`["First Call"]`), nil).Twice()
+	assert.Nil(t, cache.WatchEndpoint(path))
+	assert.Nil(t, cache.Refresh(path))
+	m.On("FetchAll", mock.Anything, path).Return([]byte(nil), errors.New("upstream down")).Once()
+	assert.NotNil(t, cache.Refresh(path))
+
+	assert.Len(t, updates, 2, "failed refreshes aren't updates")
+	assert.True(t, updates[0].Changed)
+	assert.False(t, updates[1].Changed)
+	assert.Equal(t, path, updates[1].Path)
+	assert.Equal(t, []byte(`["First Call"]`), updates[1].Data)
+	assert.True(t, updates[1].Watched)
+	assert.Empty(t, updates[0].PreviousVersion, "new entries have nothing before them")
+	assert.Equal(t, updates[0].Version, updates[1].PreviousVersion)
+	assert.Equal(t, updates[0].Data, updates[1].Previous)
+}
+
+func TestCachedApiAgainstFake(t *testing.T) {
+	fake := fakegithub.New()
+	defer fake.Close()
+	fake.AddOrg(fakegithub.Org{Login: "Netflix", Repos: fakegithub.GenerateRepos("Netflix", 150)})
+	cache := NewCachedAPI(apiclient.NewGithubWithOptions(apiclient.GithubOptions{BaseURL: fake.URL}), tLog(t))
+	path := "/orgs/Netflix/repos"
+
+	assert.Nil(t, cache.WatchEndpoint(path))
+	assert.Equal(t, 2, fake.RequestCount(path), "every page is fetched")
+	data, err := cache.Fetch(context.Background(), path)
+	assert.Nil(t, err)
+	var repos []fakegithub.Repo
+	assert.Nil(t, json.Unmarshal(data, &repos))
+	assert.Len(t, repos, 150)
+	assert.Equal(t, 2, fake.RequestCount(path), "served from the cache")
+
+	fake.SetRepos("Netflix", fakegithub.GenerateRepos("Netflix", 3))
+	assert.Nil(t, cache.Refresh(path))
+	data, err = cache.Fetch(context.Background(), path)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &repos))
+	assert.Len(t, repos, 3)
+	history, err := cache.History(path)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+
+	// Uncached paths go straight through
+	_, err = cache.Fetch(context.Background(), "/orgs/Netflix")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fake.RequestCount("/orgs/Netflix"))
+}
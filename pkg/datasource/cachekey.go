@@ -0,0 +1,61 @@
+package datasource
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query params that never change the response or shouldn't be sent upstream.
+// Auth params are dropped so callers can't swap in their own credentials.
+var IgnoredQueryParams = map[string]bool{
+	"_":             true, // Cache buster added by some http clients
+	"access_token":  true,
+	"client_id":     true,
+	"client_secret": true,
+}
+
+// Params that pick a single page of results, FetchAll sets these itself.
+var PaginationQueryParams = []string{"page", "per_page"}
+
+// Normalize a path & query into the key used for caching & coalescing requests.
+// Query params are sorted and ignored params removed, e.g. /search?q=a&_=1&b=2 -> /search?b=2&q=a
+func CacheKey(path string) string {
+	path, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return path + "?" + rawQuery // Leave anything we can't parse as is rather than guess
+	}
+	for param := range query {
+		if IgnoredQueryParams[param] {
+			query.Del(param)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode() // Encode sorts by key
+}
+
+// Key for a watched endpoint. Watched endpoints follow every page so pagination params are dropped.
+// Requests for a specific page have a different key and are proxied rather than served the full list.
+func WatchKey(path string) string {
+	key := CacheKey(path)
+	path, rawQuery, ok := strings.Cut(key, "?")
+	if !ok {
+		return key
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return key
+	}
+	for _, param := range PaginationQueryParams {
+		query.Del(param)
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
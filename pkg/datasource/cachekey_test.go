@@ -0,0 +1,33 @@
+package datasource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheKey(t *testing.T) {
+	cases := map[string]string{
+		"/orgs/Netflix":                           "/orgs/Netflix",
+		"/orgs/Netflix?":                          "/orgs/Netflix",
+		"/search/repositories?q=foo":              "/search/repositories?q=foo",
+		"/search/repositories?sort=stars&q=a+b":   "/search/repositories?q=a+b&sort=stars",
+		"/search/repositories?q=foo&_=1679586123": "/search/repositories?q=foo",
+		"/orgs/Netflix/repos?access_token=abc":    "/orgs/Netflix/repos",
+		"/orgs/Netflix/repos?page=2&per_page=10":  "/orgs/Netflix/repos?page=2&per_page=10",
+	}
+	for path, expected := range cases {
+		assert.Equal(t, expected, CacheKey(path), path)
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	cases := map[string]string{
+		"/orgs/Netflix/repos":                             "/orgs/Netflix/repos",
+		"/orgs/Netflix/repos?page=2&per_page=10":          "/orgs/Netflix/repos",
+		"/orgs/Netflix/repos?type=sources&page=2&_=12345": "/orgs/Netflix/repos?type=sources",
+	}
+	for path, expected := range cases {
+		assert.Equal(t, expected, WatchKey(path), path)
+	}
+}
@@ -0,0 +1,86 @@
+package datasource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// Payloads smaller than this aren't worth compressing.
+const MinCompressSize = 1024
+
+// Content codings stored alongside watched entries, in order of preference.
+var ContentEncodings = []string{EncodingBrotli, EncodingGzip}
+
+// Compress the payload with each of the content encodings.
+// Encodings that don't shrink the payload are left out.
+func precompress(data []byte) (map[string][]byte, error) {
+	if len(data) < MinCompressSize {
+		return nil, nil
+	}
+	variants := make(map[string][]byte, len(ContentEncodings))
+	for _, encoding := range ContentEncodings {
+		compressed, err := compress(encoding, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			variants[encoding] = compressed
+		}
+	}
+	return variants, nil
+}
+
+// Gzip is cheap enough to run at its best level since this runs once per refresh rather than per request.
+// Brotli's top levels take seconds on large payloads so it uses the default.
+func compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingBrotli:
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case EncodingGzip:
+		gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = gw
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(encoding string, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "":
+		return data, nil
+	case EncodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(data))
+	case EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	return io.ReadAll(r)
+}
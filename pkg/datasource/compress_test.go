@@ -0,0 +1,32 @@
+package datasource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecompress(t *testing.T) {
+	variants, err := precompress([]byte(`["small"]`))
+	assert.Nil(t, err)
+	assert.Empty(t, variants, "small payloads aren't compressed")
+
+	payload := bytes.Repeat([]byte(`{"full_name":"Netflix/astyanax","stargazers_count":1036},`), 100)
+	variants, err = precompress(payload)
+	assert.Nil(t, err)
+	assert.Len(t, variants, 2)
+
+	gr, err := gzip.NewReader(bytes.NewReader(variants[EncodingGzip]))
+	assert.Nil(t, err)
+	unzipped, err := io.ReadAll(gr)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, unzipped)
+
+	unbrotlied, err := io.ReadAll(brotli.NewReader(bytes.NewReader(variants[EncodingBrotli])))
+	assert.Nil(t, err)
+	assert.Equal(t, payload, unbrotlied)
+}
@@ -0,0 +1,194 @@
+package datasource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeta    = []byte("meta")    // EntryMeta per key, kept apart so listing doesn't read the data
+	bucketData    = []byte("data")    // Raw & precompressed data per key
+	bucketHistory = []byte("history") // A bucket of snapshots per key, keyed by when they were added
+)
+
+// What's stored in the data bucket for each key.
+type diskData struct {
+	Data    []byte
+	Encoded map[string][]byte
+}
+
+// Keeps entries in an embedded bolt database so large caches live outside the heap
+// and survive restarts. Data is read from disk on every Get.
+type DiskStore struct {
+	db *bolt.DB
+}
+
+// Opens or creates the database file. Only one process can have it open at a time.
+func NewDiskStore(path string) (*DiskStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketMeta, bucketData, bucketHistory} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DiskStore{db: db}, nil
+}
+
+func (d *DiskStore) Get(key string) (*ApiData, error) {
+	entry := &ApiData{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta).Get([]byte(key))
+		if meta == nil {
+			return ErrNotCached
+		}
+		if err := gobDecode(meta, &entry.EntryMeta); err != nil {
+			return err
+		}
+		var data diskData
+		if err := gobDecode(tx.Bucket(bucketData).Get([]byte(key)), &data); err != nil {
+			return err
+		}
+		entry.Data, entry.Encoded = data.Data, data.Encoded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (d *DiskStore) Put(key string, entry *ApiData) error {
+	meta, err := gobEncode(entry.EntryMeta)
+	if err != nil {
+		return err
+	}
+	data, err := gobEncode(diskData{Data: entry.Data, Encoded: entry.Encoded})
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketMeta).Put([]byte(key), meta); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketData).Put([]byte(key), data)
+	})
+}
+
+func (d *DiskStore) Delete(key string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketMeta).Get([]byte(key)) == nil {
+			return ErrNotCached
+		}
+		for _, bucket := range [][]byte{bucketMeta, bucketData} {
+			if err := tx.Bucket(bucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketHistory).DeleteBucket([]byte(key)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (d *DiskStore) List() (map[string]EntryMeta, error) {
+	metas := map[string]EntryMeta{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).ForEach(func(key, value []byte) error {
+			var meta EntryMeta
+			if err := gobDecode(value, &meta); err != nil {
+				return err
+			}
+			metas[string(key)] = meta
+			return nil
+		})
+	})
+	return metas, err
+}
+
+// Each snapshot is its own value so adding one doesn't rewrite the rest of the history.
+func (d *DiskStore) AddSnapshot(key string, snapshot Snapshot, limit int) error {
+	value, err := gobEncode(snapshot)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		history, err := tx.Bucket(bucketHistory).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		seq, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := history.Put(sequenceKey(seq), value); err != nil {
+			return err
+		}
+		// Sequence keys sort oldest first, anything limit or more behind this one is dropped
+		cursor := history.Cursor()
+		for k, _ := cursor.First(); k != nil && binary.BigEndian.Uint64(k)+uint64(limit) <= seq; k, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DiskStore) Snapshots(key string) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	err := d.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(bucketHistory).Bucket([]byte(key))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(_, value []byte) error {
+			var snapshot Snapshot
+			if err := gobDecode(value, &snapshot); err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+			return nil
+		})
+	})
+	return snapshots, err
+}
+
+func (d *DiskStore) Close() error {
+	return d.db.Close()
+}
+
+func gobEncode(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Bolt's values are only valid for the life of the transaction, decoding copies them out.
+func gobDecode(data []byte, value any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// Big endian so keys sort in the order they were added.
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,174 @@
+package datasource
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/njo/nfcache/pkg/tracing"
+)
+
+const (
+	PeerPath         = "/_nfcache/peer" // Route replicas serve cache entries to each other on
+	PeerParamKey     = "key"
+	PeerParamRefresh = "refresh"
+
+	DefaultPeerVNodes = 64               // Points on the hash ring per peer, evens out ownership
+	PeerRetryInterval = 30 * time.Second // How long a peer that failed a request is skipped for
+)
+
+// The peer is up but couldn't give us the key, e.g. because upstream failed for it too.
+type PeerResponseError struct {
+	Peer       string
+	Key        string
+	StatusCode int
+}
+
+func (e *PeerResponseError) Error() string {
+	return fmt.Sprintf("peer %s returned %d for %s", e.Peer, e.StatusCode, e.Key)
+}
+
+// Only failures to reach the peer count against it, error responses are passed back.
+func peerUnreachable(err error) bool {
+	var responseErr *PeerResponseError
+	return !errors.As(err, &responseErr)
+}
+
+// Consistent hash of cache keys onto a static list of peers.
+type hashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+func newHashRing(peers []string, vnodes int) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]string, len(peers)*vnodes)}
+	for _, peer := range peers {
+		for i := 0; i < vnodes; i++ {
+			point := crc32.ChecksumIEEE([]byte(peer + "#" + strconv.Itoa(i)))
+			if _, taken := ring.owners[point]; taken {
+				continue // Vanishingly rare, first peer keeps the point
+			}
+			ring.owners[point] = peer
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// First peer clockwise from the key that isn't skipped. Returns "" if every peer is skipped.
+func (r *hashRing) owner(key string, skip func(peer string) bool) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	for i := 0; i < len(r.points); i++ {
+		peer := r.owners[r.points[(start+i)%len(r.points)]]
+		if !skip(peer) {
+			return peer
+		}
+	}
+	return ""
+}
+
+// Replicas sharing one cache. Each key is owned by one peer which refreshes it from upstream,
+// the others copy it from the owner. Peers that fail requests are skipped for a while so
+// ownership of their keys moves to the next peer on the ring. Safe for concurrent use.
+type Peers struct {
+	self   string // Base url of this replica, must be one of the peers
+	ring   *hashRing
+	token  string // Bearer token sent to & required from peers
+	client *http.Client
+
+	lock *sync.Mutex
+	down map[string]time.Time // Peer to when it can be tried again
+}
+
+// Peers are base urls e.g. http://10.0.0.1:8080, every replica must be given the same list.
+// The token is required, without it anyone could fetch through the peer route.
+func NewPeers(self string, peers []string, token string) (*Peers, error) {
+	if token == "" {
+		return nil, errors.New("peers need a token to authenticate each other")
+	}
+	found := false
+	for _, peer := range peers {
+		found = found || peer == self
+	}
+	if !found {
+		return nil, fmt.Errorf("peer list doesn't include this replica (%s)", self)
+	}
+	return &Peers{
+		self:   self,
+		ring:   newHashRing(peers, DefaultPeerVNodes),
+		token:  token,
+		client: &http.Client{Timeout: DefaultFetchTimeoutSec * time.Second},
+		lock:   &sync.Mutex{},
+		down:   map[string]time.Time{},
+	}, nil
+}
+
+// Peer responsible for refreshing the key from upstream, skipping peers marked down.
+func (p *Peers) Owner(key string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	return p.ring.owner(key, func(peer string) bool {
+		return peer != p.self && now.Before(p.down[peer])
+	})
+}
+
+func (p *Peers) IsSelf(peer string) bool {
+	return peer == p.self
+}
+
+func (p *Peers) markDown(peer string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.down[peer] = time.Now().Add(PeerRetryInterval)
+}
+
+// Checks a peer request carries the token.
+func (p *Peers) Authorized(r *http.Request) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(p.token)) == 1
+}
+
+// Get the owner's copy of the key, asking it to refresh from upstream first if refresh is set.
+func (p *Peers) Fetch(ctx context.Context, peer string, key string, refresh bool) ([]byte, error) {
+	query := url.Values{PeerParamKey: {key}}
+	if refresh {
+		query.Set(PeerParamRefresh, "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+PeerPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", apiclient.UserAgent)
+	tracing.Inject(ctx, req.Header)
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &PeerResponseError{Peer: peer, Key: key, StatusCode: res.StatusCode}
+	}
+	return body, nil
+}
@@ -0,0 +1,49 @@
+package datasource
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRing(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	ring := newHashRing(peers, DefaultPeerVNodes)
+	none := func(string) bool { return false }
+
+	owned := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("/orgs/%d", i)
+		owner := ring.owner(key, none)
+		assert.Equal(t, owner, newHashRing(peers, DefaultPeerVNodes).owner(key, none), "ownership is stable across replicas")
+		owned[owner]++
+
+		// Skipping a peer only moves the keys it owned
+		withoutB := ring.owner(key, func(peer string) bool { return peer == "http://b" })
+		if owner != "http://b" {
+			assert.Equal(t, owner, withoutB)
+		} else {
+			assert.NotEqual(t, "http://b", withoutB)
+		}
+	}
+	for _, peer := range peers {
+		assert.Greater(t, owned[peer], 50, "%s owns a fair share", peer)
+	}
+	assert.Equal(t, "", ring.owner("/", func(string) bool { return true }))
+}
+
+func TestPeersOwner(t *testing.T) {
+	_, err := NewPeers("http://self", []string{"http://a"}, "token")
+	assert.NotNil(t, err, "self must be in the peer list")
+	_, err = NewPeers("http://a", []string{"http://a", "http://b"}, "")
+	assert.NotNil(t, err, "a token is required")
+
+	p, err := NewPeers("http://a", []string{"http://a", "http://b"}, "token")
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		p.markDown("http://a") // Never skips itself
+		p.markDown("http://b")
+		assert.Equal(t, "http://a", p.Owner(fmt.Sprintf("/orgs/%d", i)))
+	}
+}
@@ -0,0 +1,117 @@
+package datasource
+
+import (
+	"sync"
+	"time"
+)
+
+// Metadata kept alongside cached data, small enough to list for every entry.
+type EntryMeta struct {
+	LastUpdated time.Time
+	Version     string // Hash of the data, changes whenever the content does
+	Size        int
+	EncodedSize map[string]int // Sizes of the precompressed copies keyed by content encoding
+
+	Watched     bool      // Kept up to date by the updater, otherwise a read-through entry
+	LastError   string    // Error from the most recent failed refresh, cleared on success
+	LastErrorAt time.Time // When LastError happened
+}
+
+// A past version of a watched entry's data, see CachedAPI.History.
+type Snapshot struct {
+	Version  string
+	Taken    time.Time // When this version was first fetched
+	Encoding string    // Content encoding of Data, snapshots are kept compressed when they can be
+	Data     []byte
+}
+
+// Storage for cache entries keyed by cache key. Entries are treated as immutable,
+// changes are made by putting a modified copy. Implementations must be safe for concurrent use.
+type Store interface {
+	// Returns ErrNotCached if there's no entry for the key.
+	Get(key string) (*ApiData, error)
+	Put(key string, entry *ApiData) error
+	// Removes the entry and its snapshots. Returns ErrNotCached if there's no entry for the key.
+	Delete(key string) error
+	// Metadata for every entry keyed by cache key, without loading the data.
+	List() (map[string]EntryMeta, error)
+	// Adds to the key's history, dropping the oldest snapshots once there are more than limit.
+	AddSnapshot(key string, snapshot Snapshot, limit int) error
+	// History for the key oldest first, empty if there isn't any.
+	Snapshots(key string) ([]Snapshot, error)
+	Close() error
+}
+
+// Keeps entries on the heap, they're lost on restart.
+type MemoryStore struct {
+	entries   map[string]*ApiData
+	snapshots map[string][]Snapshot
+	lock      *sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*ApiData), snapshots: make(map[string][]Snapshot), lock: &sync.RWMutex{}}
+}
+
+func (m *MemoryStore) Get(key string) (*ApiData, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, ErrNotCached
+	}
+	return entry, nil
+}
+
+func (m *MemoryStore) Put(key string, entry *ApiData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.entries[key] = entry
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.entries[key]; !ok {
+		return ErrNotCached
+	}
+	delete(m.entries, key)
+	delete(m.snapshots, key)
+	return nil
+}
+
+func (m *MemoryStore) List() (map[string]EntryMeta, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	metas := make(map[string]EntryMeta, len(m.entries))
+	for key, entry := range m.entries {
+		metas[key] = entry.EntryMeta
+	}
+	return metas, nil
+}
+
+func (m *MemoryStore) AddSnapshot(key string, snapshot Snapshot, limit int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.snapshots[key] = trimSnapshots(append(m.snapshots[key], snapshot), limit)
+	return nil
+}
+
+func (m *MemoryStore) Snapshots(key string) ([]Snapshot, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]Snapshot(nil), m.snapshots[key]...), nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Keep the newest limit snapshots.
+func trimSnapshots(snapshots []Snapshot, limit int) []Snapshot {
+	if len(snapshots) <= limit {
+		return snapshots
+	}
+	return append([]Snapshot(nil), snapshots[len(snapshots)-limit:]...)
+}
@@ -0,0 +1,97 @@
+package datasource
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tDiskStore(t *testing.T, path string) *DiskStore {
+	store, err := NewDiskStore(path)
+	if err != nil {
+		t.Fatalf("unable to open disk store: %v", err)
+	}
+	return store
+}
+
+// Same behaviour is expected from every store.
+func TestStores(t *testing.T) {
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"disk":   tDiskStore(t, filepath.Join(t.TempDir(), "cache.db")),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			_, err := store.Get("/missing")
+			assert.ErrorIs(t, err, ErrNotCached)
+			assert.ErrorIs(t, store.Delete("/missing"), ErrNotCached)
+
+			entry := &ApiData{
+				EntryMeta: EntryMeta{
+					LastUpdated: time.Now().UTC().Truncate(time.Second),
+					Version:     "abc",
+					Size:        4,
+					EncodedSize: map[string]int{EncodingGzip: 2},
+					Watched:     true,
+				},
+				Data:    []byte("data"),
+				Encoded: map[string][]byte{EncodingGzip: []byte("gz")},
+			}
+			assert.Nil(t, store.Put("/a", entry))
+			got, err := store.Get("/a")
+			assert.Nil(t, err)
+			assert.Equal(t, entry, got)
+
+			metas, err := store.List()
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]EntryMeta{"/a": entry.EntryMeta}, metas)
+
+			snapshots, err := store.Snapshots("/a")
+			assert.Nil(t, err)
+			assert.Empty(t, snapshots)
+			for _, version := range []string{"v1", "v2", "v3"} {
+				assert.Nil(t, store.AddSnapshot("/a", Snapshot{Version: version, Data: []byte(version)}, 2))
+			}
+			snapshots, err = store.Snapshots("/a")
+			assert.Nil(t, err)
+			assert.Equal(t, []Snapshot{{Version: "v2", Data: []byte("v2")}, {Version: "v3", Data: []byte("v3")}}, snapshots, "oldest are dropped")
+			assert.Nil(t, store.AddSnapshot("/a", Snapshot{Version: "v4", Data: []byte("v4")}, 1))
+			snapshots, _ = store.Snapshots("/a")
+			assert.Equal(t, []Snapshot{{Version: "v4", Data: []byte("v4")}}, snapshots, "a lower limit drops everything past it")
+
+			assert.Nil(t, store.Delete("/a"))
+			metas, _ = store.List()
+			assert.Empty(t, metas)
+			snapshots, _ = store.Snapshots("/a")
+			assert.Empty(t, snapshots, "history goes with the entry")
+		})
+	}
+}
+
+func TestDiskStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	m := new(apiclient.ApiClientMock)
+	m.On("FetchAll", mock.Anything, "/a").Return([]byte(`["a"]`), nil).Once()
+
+	store := tDiskStore(t, path)
+	cache := NewCachedAPIWithStore(m, m, store, tLog(t))
+	assert.Nil(t, cache.WatchEndpoint("/a"))
+	assert.Nil(t, store.Close())
+
+	// The reopened cache serves the entry without going upstream
+	store = tDiskStore(t, path)
+	defer store.Close()
+	cache = NewCachedAPIWithStore(m, m, store, tLog(t))
+	assert.Nil(t, cache.WatchEndpoint("/a"))
+	p, err := cache.FetchPayload(context.Background(), "/a", "")
+	assert.Nil(t, err)
+	assert.True(t, p.Cached)
+	assert.Equal(t, `["a"]`, string(p.Data))
+	m.AssertExpectations(t)
+}
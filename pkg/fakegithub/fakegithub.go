@@ -0,0 +1,373 @@
+// Package fakegithub is an in-process stand in for the github api, served with httptest.
+// It serves orgs, members & repos with github's pagination, ETag & rate limit headers,
+// and can be made slow, failing or strict about tokens for end to end tests.
+package fakegithub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPerPage    = 30 // Same as github when per_page isn't sent
+	DefaultMaxPerPage = 100
+	DefaultRateLimit  = 5000
+)
+
+type Org struct {
+	Login   string
+	ID      int64
+	Members []User
+	Repos   []Repo
+}
+
+type User struct {
+	Login string `json:"login"`
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+}
+
+type Repo struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	FullName   string `json:"full_name"`
+	Stars      int    `json:"stargazers_count"`
+	Forks      int    `json:"forks_count"`
+	OpenIssues int    `json:"open_issues_count"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// A request as the fake saw it.
+type Request struct {
+	Method string
+	Path   string // Without the query
+	Query  string
+	Token  string // "" for unauthenticated requests
+}
+
+// Github api on a local port, see URL. Safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	lock       *sync.Mutex
+	orgs       map[string]*Org // Keyed by lower case login, github's org names aren't case sensitive
+	latency    time.Duration
+	errors     map[string]int // Status to fail each path with
+	tokens     map[string]bool
+	maxPerPage int
+	limit      int
+	remaining  int
+	reset      time.Time
+	requests   []Request
+}
+
+// Starts the fake with no orgs, call Close when done.
+func New() *Server {
+	s := &Server{
+		lock:       &sync.Mutex{},
+		orgs:       map[string]*Org{},
+		errors:     map[string]int{},
+		tokens:     map[string]bool{},
+		maxPerPage: DefaultMaxPerPage,
+		limit:      DefaultRateLimit,
+		remaining:  DefaultRateLimit,
+		reset:      time.Now().Add(time.Hour),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Add or replace an org. Members & repos are served in the order given.
+func (s *Server) AddOrg(org Org) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orgs[strings.ToLower(org.Login)] = &org
+}
+
+// Replace an org's repos, e.g. to make the next refresh see new data. Returns false for unknown orgs.
+func (s *Server) SetRepos(login string, repos []Repo) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.orgs[strings.ToLower(login)]
+	if ok {
+		org.Repos = repos
+	}
+	return ok
+}
+
+// Delay every response, requests that are cancelled while waiting get nothing.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency = latency
+}
+
+// Fail every request for the path (without a query) with the status, 0 to stop failing.
+func (s *Server) SetError(path string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == 0 {
+		delete(s.errors, path)
+		return
+	}
+	s.errors[path] = status
+}
+
+// Only accept requests with one of the tokens, others get a 401. No tokens accepts everything.
+func (s *Server) RequireTokens(tokens ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens = map[string]bool{}
+	for _, token := range tokens {
+		s.tokens[token] = true
+	}
+}
+
+// Cap per_page below github's 100, handy for testing long runs of pages with few items.
+func (s *Server) SetMaxPerPage(max int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxPerPage = max
+}
+
+// Requests left before the fake starts answering with rate limit errors.
+func (s *Server) SetRateLimit(limit int, remaining int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limit, s.remaining = limit, remaining
+}
+
+// Every request served so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// How many requests were made for the path, every page counts.
+func (s *Server) RequestCount(path string) int {
+	count := 0
+	for _, req := range s.Requests() {
+		if req.Path == path {
+			count++
+		}
+	}
+	return count
+}
+
+// Repos named <org>-0, <org>-1... with counts that differ so sorted views are predictable.
+func GenerateRepos(org string, count int) []Repo {
+	repos := make([]Repo, count)
+	for i := range repos {
+		name := fmt.Sprintf("%s-%d", strings.ToLower(org), i)
+		repos[i] = Repo{
+			ID:         int64(1000 + i),
+			Name:       name,
+			FullName:   org + "/" + name,
+			Stars:      i * 10,
+			Forks:      i * 2,
+			OpenIssues: count - i,
+			UpdatedAt:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+		}
+	}
+	return repos
+}
+
+// Members named <org>-member-0, <org>-member-1...
+func GenerateMembers(org string, count int) []User {
+	members := make([]User, count)
+	for i := range members {
+		members[i] = User{Login: fmt.Sprintf("%s-member-%d", strings.ToLower(org), i), ID: int64(5000 + i), Type: "User"}
+	}
+	return members
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(r.Header.Get("Authorization"), "token"), "Bearer"))
+	s.lock.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Token: token})
+	latency := s.latency
+	s.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.tokens) > 0 && !s.tokens[token] {
+		writeError(w, http.StatusUnauthorized, "Bad credentials")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.remaining <= 0 {
+		s.setRateLimitHeaders(w)
+		writeError(w, http.StatusForbidden, "API rate limit exceeded")
+		return
+	}
+	if status, ok := s.errors[r.URL.Path]; ok {
+		s.remaining--
+		s.setRateLimitHeaders(w)
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	body, items, ok := s.route(r.URL.Path)
+	if !ok {
+		s.remaining--
+		s.setRateLimitHeaders(w)
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if items != nil {
+		page, ok := s.paginate(w, r, items)
+		if !ok {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid pagination")
+			return
+		}
+		body = page
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		s.setRateLimitHeaders(w) // Conditional hits don't use up the rate limit, like github
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.remaining--
+	s.setRateLimitHeaders(w)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+// Body for the path, or the full list of items when the path is paginated. Caller must hold the lock.
+func (s *Server) route(path string) (any, []any, bool) {
+	if path == "/" {
+		return map[string]string{
+			"organization_url":              s.URL + "/orgs/{org}",
+			"organization_repositories_url": s.URL + "/orgs/{org}/repos{?type,page,per_page,sort}",
+		}, nil, true
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "orgs" {
+		return nil, nil, false
+	}
+	org, ok := s.orgs[strings.ToLower(parts[1])]
+	if !ok {
+		return nil, nil, false
+	}
+	if len(parts) == 2 {
+		return map[string]any{
+			"login":        org.Login,
+			"id":           org.ID,
+			"url":          s.URL + "/orgs/" + org.Login,
+			"repos_url":    s.URL + "/orgs/" + org.Login + "/repos",
+			"public_repos": len(org.Repos),
+			"type":         "Organization",
+		}, nil, true
+	}
+	items := []any{}
+	switch parts[2] {
+	case "members":
+		for _, member := range org.Members {
+			items = append(items, member)
+		}
+	case "repos":
+		for _, repo := range org.Repos {
+			items = append(items, repo)
+		}
+	default:
+		return nil, nil, false
+	}
+	return nil, items, true
+}
+
+// Slice out the requested page & set the Link header the way github does. Caller must hold the lock.
+func (s *Server) paginate(w http.ResponseWriter, r *http.Request, items []any) ([]any, bool) {
+	query := r.URL.Query()
+	perPage, page := DefaultPerPage, 1
+	var err error
+	if value := query.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 {
+			return nil, false
+		}
+	}
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return nil, false
+		}
+	}
+	if perPage > s.maxPerPage {
+		perPage = s.maxPerPage
+	}
+	lastPage := (len(items) + perPage - 1) / perPage
+	if lastPage == 0 {
+		lastPage = 1
+	}
+
+	link := func(page int, rel string) string {
+		q := r.URL.Query()
+		q.Set("per_page", strconv.Itoa(perPage))
+		q.Set("page", strconv.Itoa(page))
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, s.URL, r.URL.Path, q.Encode(), rel)
+	}
+	var links []string
+	if page > 1 {
+		links = append(links, link(page-1, "prev"), link(1, "first"))
+	}
+	if page < lastPage {
+		links = append(links, link(page+1, "next"), link(lastPage, "last"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	start := (page - 1) * perPage
+	if start >= len(items) {
+		return []any{}, true
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end], true
+}
+
+// Caller must hold the lock.
+func (s *Server) setRateLimitHeaders(w http.ResponseWriter) {
+	remaining := s.remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
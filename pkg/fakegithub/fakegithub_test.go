@@ -0,0 +1,65 @@
+package fakegithub
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tGet(t *testing.T, url string, header http.Header) (*http.Response, []map[string]any) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Nil(t, err)
+	if header != nil {
+		req.Header = header
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	var items []map[string]any
+	if res.StatusCode == http.StatusOK {
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&items))
+	}
+	return res, items
+}
+
+func TestServer(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.AddOrg(Org{Login: "Netflix", ID: 913567, Repos: GenerateRepos("Netflix", 5)})
+	s.SetRateLimit(10, 3)
+
+	res, items := tGet(t, s.URL+"/orgs/netflix/repos?per_page=2&page=2", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "Netflix/netflix-2", items[0]["full_name"])
+	assert.True(t, strings.Contains(res.Header.Get("Link"), `page=3&per_page=2>; rel="next"`))
+	assert.True(t, strings.Contains(res.Header.Get("Link"), `rel="prev"`))
+	assert.Equal(t, "2", res.Header.Get("X-RateLimit-Remaining"))
+
+	// Matching ETags get a 304 that doesn't use up the rate limit
+	etag := res.Header.Get("ETag")
+	res, _ = tGet(t, s.URL+"/orgs/netflix/repos?per_page=2&page=2", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Equal(t, "2", res.Header.Get("X-RateLimit-Remaining"))
+
+	res, items = tGet(t, s.URL+"/orgs/netflix/repos?per_page=2&page=3", nil)
+	assert.Len(t, items, 1)
+	assert.False(t, strings.Contains(res.Header.Get("Link"), `rel="next"`), "no next on the last page")
+
+	s.SetError("/orgs/Netflix", http.StatusBadGateway)
+	res, _ = tGet(t, s.URL+"/orgs/Netflix", nil)
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	res, _ = tGet(t, s.URL+"/orgs/Netflix", nil)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "rate limited")
+
+	s.SetRateLimit(10, 10)
+	s.RequireTokens("good")
+	res, _ = tGet(t, s.URL+"/orgs/netflix/repos", http.Header{"Authorization": {"token bad"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, _ = tGet(t, s.URL+"/orgs/other/repos", http.Header{"Authorization": {"token good"}})
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, 7, s.RequestCount("/orgs/netflix/repos")+s.RequestCount("/orgs/Netflix")+s.RequestCount("/orgs/other/repos"))
+}
@@ -0,0 +1,59 @@
+// Package requestid carries a per-request ID through contexts so a request can be
+// followed in the logs from ingress through the cache to the upstream api.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+)
+
+const (
+	Header   = "X-Request-ID"
+	LogField = "request_id"
+	MaxLen   = 128 // Longer incoming ids are replaced rather than logged
+)
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// The request's ID, "" if there isn't one.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Random 16 byte hex ID.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "" // Not worth failing a request over
+	}
+	return hex.EncodeToString(b)
+}
+
+// Use the caller's ID if it's sensible, otherwise make a new one.
+func FromHeader(value string) string {
+	if value == "" || len(value) > MaxLen {
+		return New()
+	}
+	for _, r := range value {
+		if r < '!' || r > '~' { // Printable ascii only, ids end up in logs & response headers
+			return New()
+		}
+	}
+	return value
+}
+
+// Logger that tags every line with the context's request ID, if it has one.
+func Logger(ctx context.Context, log *zap.SugaredLogger) *zap.SugaredLogger {
+	if id := FromContext(ctx); id != "" {
+		return log.With(LogField, id)
+	}
+	return log
+}
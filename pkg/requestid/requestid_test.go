@@ -0,0 +1,31 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromHeader(t *testing.T) {
+	assert.Equal(t, "abc-123", FromHeader("abc-123"))
+	assert.Len(t, FromHeader(""), 32)
+	assert.Len(t, FromHeader("has space"), 32)
+	assert.Len(t, FromHeader("bad\nnewline"), 32)
+	assert.Len(t, FromHeader(strings.Repeat("a", MaxLen+1)), 32)
+	assert.NotEqual(t, New(), New())
+}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := zap.New(core).Sugar()
+
+	Logger(context.Background(), log).Info("no id")
+	Logger(NewContext(context.Background(), "abc"), log).Info("with id")
+	assert.Empty(t, logs.All()[0].ContextMap())
+	assert.Equal(t, map[string]any{LogField: "abc"}, logs.All()[1].ContextMap())
+}
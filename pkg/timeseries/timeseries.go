@@ -0,0 +1,144 @@
+// Package timeseries keeps compact step series of integer values, e.g. star counts per repo.
+// A point is only recorded when a value changes, it holds until the next point.
+package timeseries
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const DefaultRetention = 30 * 24 * time.Hour
+
+type Point struct {
+	At    int64 // Unix seconds
+	Value int64
+}
+
+// Series keyed by name, kept in memory & optionally saved to a file after each Record.
+// Safe for concurrent use.
+type DB struct {
+	path      string // "" to keep series in memory only
+	retention time.Duration
+
+	lock   *sync.RWMutex
+	series map[string][]Point
+}
+
+// Series are lost on restart.
+func New(retention time.Duration) *DB {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &DB{retention: retention, lock: &sync.RWMutex{}, series: map[string][]Point{}}
+}
+
+// Loads series saved to the file, if it exists, and saves back to it after each Record.
+func Open(path string, retention time.Duration) (*DB, error) {
+	db := New(retention)
+	db.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&db.series); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// How far back series go.
+func (db *DB) Retention() time.Duration {
+	return db.retention
+}
+
+// Record the values at the given time, keyed by series name. Values that haven't changed
+// since their last point are skipped. Points past the retention are dropped from every series,
+// series that weren't recorded and haven't changed within the retention are dropped entirely.
+func (db *DB) Record(at time.Time, values map[string]int64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	cutoff := at.Add(-db.retention).Unix()
+	for name, value := range values {
+		points := db.series[name]
+		if len(points) > 0 && points[len(points)-1].Value == value {
+			continue
+		}
+		db.series[name] = append(points, Point{At: at.Unix(), Value: value})
+	}
+	for name, points := range db.series {
+		if _, recorded := values[name]; !recorded && points[len(points)-1].At <= cutoff {
+			delete(db.series, name) // e.g. a deleted repo, an unknown series reads the same as a flat one
+			continue
+		}
+		db.series[name] = prune(points, cutoff)
+	}
+	if db.path == "" {
+		return nil
+	}
+	return db.save()
+}
+
+// Keeps the last point before the cutoff since it's still the value at the cutoff.
+func prune(points []Point, cutoff int64) []Point {
+	keep := 0
+	for keep+1 < len(points) && points[keep+1].At <= cutoff {
+		keep++
+	}
+	if keep == 0 {
+		return points
+	}
+	return append([]Point(nil), points[keep:]...)
+}
+
+// Write to a temp file & rename it over the old one so a crash can't leave half a file.
+// Caller must hold the lock.
+func (db *DB) save() error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(db.series); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), db.path)
+}
+
+// Value of the series at the given time, series that start later give their first value.
+// Returns false for unknown series.
+func (db *DB) ValueAt(name string, at time.Time) (int64, bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	points := db.series[name]
+	if len(points) == 0 {
+		return 0, false
+	}
+	for i := len(points) - 1; i >= 0; i-- {
+		if points[i].At <= at.Unix() {
+			return points[i].Value, true
+		}
+	}
+	return points[0].Value, true
+}
+
+// Points for the series oldest first.
+func (db *DB) Points(name string) []Point {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return append([]Point(nil), db.series[name]...)
+}
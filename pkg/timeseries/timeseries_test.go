@@ -0,0 +1,54 @@
+package timeseries
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	db := New(10 * time.Hour)
+	start := time.Unix(1_700_000_000, 0)
+	hour := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+
+	assert.Nil(t, db.Record(hour(0), map[string]int64{"a": 1, "b": 5}))
+	assert.Nil(t, db.Record(hour(1), map[string]int64{"a": 1, "b": 6}))
+	assert.Nil(t, db.Record(hour(2), map[string]int64{"a": 3}))
+	assert.Equal(t, []Point{{At: hour(0).Unix(), Value: 1}, {At: hour(2).Unix(), Value: 3}}, db.Points("a"), "unchanged values aren't recorded")
+
+	value, ok := db.ValueAt("a", hour(1))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), value, "values hold until the next point")
+	value, ok = db.ValueAt("a", hour(5))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), value)
+	value, ok = db.ValueAt("a", hour(-1))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), value, "series that start later give their first value")
+	_, ok = db.ValueAt("missing", hour(0))
+	assert.False(t, ok)
+
+	// The last point before the retention cutoff is kept as it's still the value at the cutoff
+	assert.Nil(t, db.Record(hour(5), map[string]int64{"b": 7}))
+	assert.Nil(t, db.Record(hour(13), map[string]int64{"a": 4}))
+	assert.Equal(t, []Point{{At: hour(2).Unix(), Value: 3}, {At: hour(13).Unix(), Value: 4}}, db.Points("a"))
+	assert.Equal(t, []Point{{At: hour(1).Unix(), Value: 6}, {At: hour(5).Unix(), Value: 7}}, db.Points("b"), "series that aren't recorded are pruned too")
+	assert.Nil(t, db.Record(hour(16), map[string]int64{"a": 4}))
+	assert.Empty(t, db.Points("b"), "series left behind by the retention are dropped")
+	_, ok = db.ValueAt("b", hour(16))
+	assert.False(t, ok)
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trends.db")
+	db, err := Open(path, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultRetention, db.Retention())
+	assert.Nil(t, db.Record(time.Unix(100, 0), map[string]int64{"a": 1}))
+
+	reopened, err := Open(path, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []Point{{At: 100, Value: 1}}, reopened.Points("a"))
+}
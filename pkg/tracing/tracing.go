@@ -0,0 +1,68 @@
+// Package tracing sets up OpenTelemetry for nfcache. Until Setup is called spans are no-ops,
+// but incoming trace context is still passed on to github once the propagator is set.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	InstrumentationName = "github.com/njo/nfcache"
+	ServiceName         = "nfcache"
+)
+
+// Tracer for nfcache's spans, picks up whichever provider is set globally.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Read & write W3C trace context and baggage headers.
+func SetPropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// True when an OTLP endpoint is set through the standard OTEL_EXPORTER_OTLP_* env vars.
+func Configured() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Export spans over OTLP/HTTP, configured by the OTEL_EXPORTER_OTLP_* env vars.
+// The returned func flushes any buffered spans and must be called on shutdown.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME & OTEL_RESOURCE_ATTRIBUTES take precedence
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	SetPropagator()
+	return provider.Shutdown, nil
+}
+
+// Pick up the caller's trace context from request headers.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Pass the context's trace on in outgoing request headers.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiserver"
+	"github.com/njo/nfcache/pkg/datasource"
+	"github.com/njo/nfcache/pkg/timeseries"
+	"github.com/njo/nfcache/pkg/tracing"
+)
+
+// Runs the api server until it's sent SIGINT or SIGTERM.
+func serve(args []string) error {
+	// Load CLI Options
+	var port int
+	var github githubFlags
+	flags := newFlagSet("serve", "")
+	flags.IntVar(&port, "p", 8080, "Set the port number to listen on (Default 8080)")
+	github.register(flags)
+	flags.Parse(args)
+
+	// Set up logger
+	logger := newLogger(true)
+	defer logger.Sync() // ensure logger buffer flushed on shutdown
+
+	// Load env vars
+	loadEnv(logger)
+	githubOrgs := loadOrgs()
+	logger.Infof("Caching orgs: %v", githubOrgs)
+	adminToken := os.Getenv("ADMIN_API_TOKEN")
+	if adminToken == "" {
+		logger.Warn("ADMIN_API_TOKEN not set, admin routes are disabled")
+	}
+	var clients []apiserver.Client
+	var err error
+	if clientsFile := os.Getenv("NFCACHE_CLIENTS_FILE"); clientsFile != "" {
+		if clients, err = apiserver.LoadClients(clientsFile); err != nil {
+			logger.Fatalf("unable to load clients: %v\n", err)
+		}
+		logger.Infof("Loaded %d client keys", len(clients))
+	} else {
+		logger.Warn("NFCACHE_CLIENTS_FILE not set, public routes don't need a key")
+	}
+	health, err := loadHealthOptions()
+	if err != nil {
+		logger.Fatalf("invalid health config: %v\n", err)
+	}
+	webhookSecret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		logger.Warn("GITHUB_WEBHOOK_SECRET not set, github webhooks are disabled")
+	}
+
+	// Traces are passed on to github even when we aren't exporting our own spans
+	tracing.SetPropagator()
+	shutdownTracing := func(context.Context) error { return nil }
+	if tracing.Configured() {
+		if shutdownTracing, err = tracing.Setup(context.Background()); err != nil {
+			logger.Fatalf("unable to set up tracing: %v\n", err)
+		}
+		logger.Info("Exporting traces over OTLP")
+	}
+
+	// Init servers
+	breakers, err := github.breakers(logger)
+	if err != nil {
+		logger.Fatalf("unable to set up github client: %v\n", err)
+	}
+	var store datasource.Store = datasource.NewMemoryStore()
+	if storePath := os.Getenv("NFCACHE_STORE_PATH"); storePath != "" {
+		logger.Infof("Storing the cache in %s", storePath)
+		if store, err = datasource.NewDiskStore(storePath); err != nil {
+			logger.Fatalf("unable to open cache store: %v\n", err)
+		}
+	}
+	apiCache, err := newCachedAPI(breakers, store, logger)
+	if err != nil {
+		logger.Fatalf("%v\n", err)
+	}
+	if peerList := envList("NFCACHE_PEERS"); len(peerList) > 0 {
+		peers, err := datasource.NewPeers(os.Getenv("NFCACHE_SELF_URL"), peerList, os.Getenv("NFCACHE_PEER_TOKEN"))
+		if err != nil {
+			logger.Fatalf("invalid peer config: %v\n", err)
+		}
+		logger.Infof("Sharing the cache with peers: %v", peerList)
+		apiCache.SetPeers(peers)
+	}
+	server := apiserver.NewWithOrgs(apiCache, logger, githubOrgs)
+	server.SetAdminToken(adminToken)
+	server.SetWebhookSecret(webhookSecret)
+	server.SetBreakers(breakers...)
+	server.SetHealth(health)
+	if trendsPath := os.Getenv("NFCACHE_TRENDS_PATH"); trendsPath != "" {
+		trends, err := timeseries.Open(trendsPath, timeseries.DefaultRetention)
+		if err != nil {
+			logger.Fatalf("unable to open trends file: %v\n", err)
+		}
+		server.SetTrends(trends)
+	}
+	if err = server.SetClients(clients); err != nil {
+		logger.Fatalf("invalid client config: %v\n", err)
+	}
+	initalEndpoints := server.CachedEndpoints()
+	logger.Info("Pre-fetching initial endpoint data")
+	for _, path := range initalEndpoints {
+		logger.Infof("Fetching %s", path)
+		err = apiCache.WatchEndpoint(path) // No-op for entries already in a warmed store
+		if err != nil {
+			// Choosing to early exit here as there's probably an external api issue
+			logger.Fatalf("unable to fetch %s on startup: %v\n", path, err)
+		}
+	}
+	apiCache.Run(datasource.DefaultUpdateIntervalSec * time.Second) // Keeps the cache updated in the background
+	listenAddress := ":" + strconv.Itoa(port)
+	go server.Run(listenAddress) // Run the service in a separate thread to not block signal handler
+
+	// Wait for shutdown signals
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	server.Shutdown(5 * time.Second)
+	apiCache.Shutdown() // Can take a bit if we're in the middle of a cache update
+	if err = store.Close(); err != nil {
+		logger.Errorf("Problem closing cache store: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = shutdownTracing(ctx); err != nil {
+		logger.Errorf("Problem flushing traces: %v", err)
+	}
+	logger.Info("Service gracefully exited")
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/njo/nfcache/pkg/apiserver"
+	"github.com/njo/nfcache/pkg/datasource"
+	"go.uber.org/zap/zapcore"
+)
+
+// Fetches the cached endpoints, plus any extra paths, into a snapshot file that serve
+// can start from with NFCACHE_STORE_PATH. Entries already in the snapshot are refreshed.
+func warm(args []string) error {
+	var output string
+	var verbose bool
+	var github githubFlags
+	flags := newFlagSet("warm", "[extra paths...]")
+	flags.StringVar(&output, "o", os.Getenv("NFCACHE_STORE_PATH"), "Snapshot file to write (Defaults to NFCACHE_STORE_PATH)")
+	flags.BoolVar(&verbose, "v", false, "Log each github request")
+	github.register(flags)
+	flags.Parse(args)
+
+	logger := newLoggerAt(verbose, zapcore.DebugLevel)
+	defer logger.Sync()
+	loadEnv(logger)
+	if output == "" {
+		output = os.Getenv("NFCACHE_STORE_PATH") // May have come from the .env file
+	}
+	if output == "" {
+		return errors.New("no snapshot file, set -o or NFCACHE_STORE_PATH")
+	}
+
+	breakers, err := github.breakers(logger)
+	if err != nil {
+		return err
+	}
+	store, err := datasource.NewDiskStore(output)
+	if err != nil {
+		return fmt.Errorf("unable to open %s, a running server may have it open: %w", output, err)
+	}
+	defer store.Close()
+	apiCache, err := newCachedAPI(breakers, store, logger)
+	if err != nil {
+		return err
+	}
+
+	failed := warmPaths(apiCache, append(apiserver.CachedOrgEndpoints(loadOrgs()), flags.Args()...), os.Stderr)
+	entries, err := listEntries(store, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := printEntries(os.Stdout, entries); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of the paths failed: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// Refreshes the paths already in the store & watches the rest. Returns the paths that failed,
+// their errors are written to errs.
+func warmPaths(apiCache *datasource.CachedAPI, paths []string, errs io.Writer) []string {
+	var failed []string
+	for _, path := range paths {
+		var err error
+		if _, cached := apiCache.Entry(path); cached {
+			err = apiCache.Refresh(path)
+		} else {
+			err = apiCache.WatchEndpoint(path)
+		}
+		if err != nil {
+			fmt.Fprintf(errs, "Unable to fetch %s: %v\n", path, err)
+			failed = append(failed, path)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmPaths(t *testing.T) {
+	cache, _, fake := tCache(t)
+	assert.Nil(t, cache.WatchEndpoint("/orgs/Netflix/repos")) // Already in the snapshot
+	fake.SetError("/orgs/Netflix/members", http.StatusBadGateway)
+
+	var errs bytes.Buffer
+	failed := warmPaths(cache, []string{"/orgs/Netflix/repos", "/orgs/Netflix", "/orgs/Netflix/members"}, &errs)
+	assert.Equal(t, []string{"/orgs/Netflix/members"}, failed)
+	assert.Contains(t, errs.String(), "Unable to fetch /orgs/Netflix/members")
+
+	assert.Equal(t, 2, fake.RequestCount("/orgs/Netflix/repos"), "cached entries are refreshed")
+	org, ok := cache.Entry("/orgs/Netflix")
+	assert.True(t, ok)
+	assert.True(t, org.Watched, "new entries are watched")
+}